@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"strings"
+	"time"
+)
+
+// Activity index - composite keys "Activity" + [day, tx time, tx id, sequence in the tx], one per emitted event.
+// Entries are only ever added, so the index is a journal of everything the chaincode did.
+const (
+	ActivityDayLayout  = "2006-01-02"
+	ActivityTimeLayout = "2006-01-02T15:04:05.000000000Z07:00" // fixed width so keys sort by time
+	MaxActivityDays    = 366
+)
+
+// Filters accepted by get_activity, empty fields match everything
+type ActivityFilter struct {
+	Names       []string `json:"names"` // event names, e.g. asset_proposed, asset_borrowed
+	AssetId     string   `json:"assetId"`
+	DonorId     string   `json:"donorId"`
+	NPOId       string   `json:"npoId"`
+	RecipientId string   `json:"recipientId"`
+	NeedId      string   `json:"needId"`
+}
+
+type ActivityPage struct {
+	Records  []ChaincodeEvent `json:"records"`
+	Bookmark string           `json:"bookmark"` // "" on the last page
+}
+
+// Write the event into the activity index, called by emit_event
+func put_activity(stub shim.ChaincodeStubInterface, event ChaincodeEvent, txTime time.Time, sequence int) error {
+	key, err := stub.CreateCompositeKey("Activity", []string{
+		txTime.Format(ActivityDayLayout),
+		txTime.Format(ActivityTimeLayout),
+		event.TxId,
+		fmt.Sprintf("%04d", sequence),
+	})
+	if err != nil {
+		return err
+	}
+
+	eventAsBytes, _ := json.Marshal(event)
+	return stub.PutState(key, eventAsBytes)
+}
+
+func matches_activity(event ChaincodeEvent, filter ActivityFilter) bool {
+	if len(filter.Names) > 0 && !contains(filter.Names, event.Name) {
+		return false
+	}
+	fields := [][2]string{
+		{filter.AssetId, event.AssetId},
+		{filter.DonorId, event.DonorId},
+		{filter.NPOId, event.NPOId},
+		{filter.RecipientId, event.RecipientId},
+		{filter.NeedId, event.NeedId},
+	}
+	for _, v := range fields {
+		if v[0] != "" && v[0] != v[1] {
+			return false
+		}
+	}
+	return true
+}
+
+// ============================================================================================================================
+// get_activity - args: from, to (RFC3339), filter JSON {names, assetId, donorId, npoId, recipientId, needId} or "",
+// page size, optional bookmark. Events in the window, oldest first, read day by day from the activity index
+// ============================================================================================================================
+func (t *SimpleChaincode) get_activity(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 4 || len(args) > 5 {
+		return shim.Error("Incorrect number of arguments. Expecting from, to, filter, page size and optional bookmark")
+	}
+
+	from, err := time.Parse(time.RFC3339, args[0])
+	if err != nil {
+		return shim.Error("{\"Error\":\"Dates must be RFC3339 - " + args[0] + "\"}")
+	}
+	to, err := time.Parse(time.RFC3339, args[1])
+	if err != nil {
+		return shim.Error("{\"Error\":\"Dates must be RFC3339 - " + args[1] + "\"}")
+	}
+	from = from.UTC()
+	to = to.UTC()
+	if to.Before(from) {
+		return shim.Error("{\"Error\":\"to is before from\"}")
+	}
+	if to.Sub(from) > MaxActivityDays*24*time.Hour {
+		return shim.Error(fmt.Sprintf("{\"Error\":\"Activity windows are at most %d days\"}", MaxActivityDays))
+	}
+
+	var filter ActivityFilter
+	if args[2] != "" {
+		decoder := json.NewDecoder(strings.NewReader(args[2]))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&filter)
+		if err != nil {
+			return shim.Error("{\"Error\":\"Invalid filter - " + err.Error() + "\"}")
+		}
+	}
+
+	// recipients are hidden from events unless the caller acts for the event's NPO or filters on its own recipient
+	view := new_recipient_view(stub)
+	redact := true
+	if filter.RecipientId != "" {
+		err = check_recipient_reader(stub, filter.RecipientId)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		redact = false
+	}
+
+	pageSize, bookmark, err := parse_page_args(args[3:])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// bookmark: "<day>~<bookmark of the day's query>"
+	firstDay := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	day := firstDay
+	dayBookmark := ""
+	if bookmark != "" {
+		parts := strings.SplitN(bookmark, "~", 2)
+		day, err = time.Parse(ActivityDayLayout, parts[0])
+		if err != nil || len(parts) != 2 {
+			return shim.Error("{\"Error\":\"Invalid bookmark\"}")
+		}
+		// a day outside the window would walk days the window limit never allowed
+		if day.Before(firstDay) || day.After(to) {
+			return shim.Error("{\"Error\":\"Bookmark is outside the window\"}")
+		}
+		dayBookmark = parts[1]
+	}
+
+	fromAsString := from.Format(ActivityTimeLayout)
+	toAsString := to.Format(ActivityTimeLayout)
+
+	var page ActivityPage
+	page.Records = []ChaincodeEvent{}
+	fetched := int32(0)
+	for !day.After(to) && fetched < pageSize {
+		dayAsString := day.Format(ActivityDayLayout)
+		resultsIterator, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination("Activity", []string{dayAsString}, pageSize-fetched, dayBookmark)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		for resultsIterator.HasNext() {
+			aKeyValue, err := resultsIterator.Next()
+			if err != nil {
+				resultsIterator.Close()
+				return shim.Error(err.Error())
+			}
+			_, keyParts, err := stub.SplitCompositeKey(aKeyValue.Key)
+			if err != nil || len(keyParts) != 4 {
+				continue
+			}
+			if keyParts[1] < fromAsString || keyParts[1] > toAsString {
+				continue
+			}
+
+			var event ChaincodeEvent
+			json.Unmarshal(aKeyValue.Value, &event)
+			if matches_activity(event, filter) {
+				if redact {
+					view.redact_event(&event)
+				}
+				page.Records = append(page.Records, event)
+			}
+		}
+		resultsIterator.Close()
+
+		fetched = fetched + metadata.FetchedRecordsCount
+		if fetched == pageSize && metadata.Bookmark != "" {
+			// the day may have more entries, resume it on the next page
+			page.Bookmark = dayAsString + "~" + metadata.Bookmark
+			break
+		}
+		day = day.AddDate(0, 0, 1)
+		dayBookmark = ""
+		if fetched == pageSize && !day.After(to) {
+			page.Bookmark = day.Format(ActivityDayLayout) + "~"
+		}
+	}
+
+	pageAsBytes, _ := json.Marshal(page)
+	return shim.Success(pageAsBytes)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func (s *mock_stub) activity(t *testing.T, caller test_identity, args ...string) ActivityPage {
+	t.Helper()
+	res := s.invoke(caller, "get_activity", args...)
+	expect_ok(t, res, "get_activity")
+	var page ActivityPage
+	json.Unmarshal(res.Payload, &page)
+	return page
+}
+
+func event_names(page ActivityPage) []string {
+	names := []string{}
+	for _, v := range page.Records {
+		names = append(names, v.Name)
+	}
+	return names
+}
+
+// Ledger time of the next transaction
+func (s *mock_stub) next_time() time.Time {
+	return mock_start.Add(time.Duration(s.txCount+1) * time.Minute)
+}
+
+func TestActivityIsReadByWindowAndFilter(t *testing.T) {
+	s := new_approved_stub(t)
+	from := s.next_time().Format(time.RFC3339)
+	expect_ok(t, s.invoke(recipient_user, "borrow_asset", "a100", "r100"), "borrow_asset")
+	expect_ok(t, s.invoke(npo_user, "get_back_asset", "a100", "r100"), "get_back_asset")
+	expect_ok(t, s.invoke(donor_user, "propose_asset", "a101", "scarf", "d100", "n100", "의류", "hash"), "propose_asset")
+	to := s.next_time().Add(-time.Second).Format(time.RFC3339)
+	expect_ok(t, s.invoke(npo_user, "give_asset", "a100", "r100"), "give_asset")
+
+	page := s.activity(t, npo_user, from, to, "", "100")
+	expect_ids(t, event_names(page), "asset_borrowed", "asset_returned", "asset_proposed")
+	if page.Bookmark != "" || page.Records[0].AssetId != "a100" || page.Records[0].RecipientId != "r100" || page.Records[0].Timestamp != from {
+		t.Fatalf("unexpected activity %+v", page)
+	}
+
+	expect_ids(t, event_names(s.activity(t, donor_user, from, "2026-03-03T00:00:00Z", `{"assetId":"a100"}`, "100")),
+		"asset_borrowed", "asset_returned", "asset_given")
+	expect_ids(t, event_names(s.activity(t, donor_user, "2026-03-02T00:00:00Z", "2026-03-03T00:00:00Z", `{"names":["asset_proposed"],"donorId":"d100"}`, "100")),
+		"asset_proposed", "asset_proposed")
+	expect_ids(t, event_names(s.activity(t, donor_user, "2026-03-03T00:00:00Z", "2026-03-04T00:00:00Z", "", "100")))
+
+	expect_error(t, s.invoke(donor_user, "get_activity", to, from, "", "100"), "get_activity ending before it starts")
+	expect_error(t, s.invoke(donor_user, "get_activity", "2026-03-02T00:00:00Z", "2027-03-04T00:00:00Z", "", "100"), "get_activity over the longest window")
+	expect_error(t, s.invoke(donor_user, "get_activity", from, to, `{"asset":"a100"}`, "100"), "get_activity with an unknown filter")
+	expect_error(t, s.invoke(donor_user, "get_activity", "monday", to, "", "100"), "get_activity with a malformed date")
+}
+
+func TestActivityPagesAcrossDays(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+	// three proposals a day for three days
+	for day := 0; day < 3; day++ {
+		for i := 0; i < 3; i++ {
+			expect_ok(t, s.invoke(donor_user, "propose_asset", "", "coat", "d100", "n100", "의류", "hash"), "propose_asset")
+		}
+		s.txCount += 24 * 60
+	}
+	from := "2026-03-02T00:00:00Z"
+	to := "2026-03-06T00:00:00Z"
+	filter := `{"names":["asset_proposed"]}`
+
+	all := s.activity(t, donor_user, from, to, filter, "100")
+	if len(all.Records) != 9 {
+		t.Fatalf("%d proposals, expected 9", len(all.Records))
+	}
+
+	paged := []string{}
+	bookmark := ""
+	for pages := 0; pages == 0 || bookmark != ""; pages++ {
+		if pages == 20 {
+			t.Fatal("get_activity does not end")
+		}
+		args := []string{from, to, filter, "2"}
+		if bookmark != "" {
+			args = append(args, bookmark)
+		}
+		page := s.activity(t, donor_user, args...)
+		if len(page.Records) > 2 {
+			t.Fatalf("page of %d records for page size 2", len(page.Records))
+		}
+		for _, v := range page.Records {
+			paged = append(paged, v.AssetId)
+		}
+		bookmark = page.Bookmark
+	}
+	want := []string{}
+	for _, v := range all.Records {
+		want = append(want, v.AssetId)
+	}
+	expect_ids(t, paged, want...)
+
+	expect_error(t, s.invoke(donor_user, "get_activity", "2026-03-03T00:00:00Z", to, filter, "2", "2026-03-02~"), "get_activity bookmarked before the window")
+	expect_error(t, s.invoke(donor_user, "get_activity", from, to, filter, "2", "tuesday"), "get_activity with a malformed bookmark")
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Roles carried in the "role" attribute of the submitter's enrollment certificate
+const (
+	RoleDonor     = "donor"
+	RoleNPO       = "npo"
+	RoleRecipient = "recipient"
+	RoleAdmin     = "admin"
+)
+
+// Who may call an Invoke function.
+// Roles lists the accepted "role" attribute values, MSPs optionally narrows the
+// caller's organisation (empty means any MSP on the channel).
+type AccessRule struct {
+	Roles []string
+	MSPs  []string
+}
+
+type CallerIdentity struct {
+	MSPID       string `json:"mspid"`
+	Role        string `json:"role"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// Links a Donor, NPO or Recipient to the X.509 identity that enrolled it.
+// Owner is the enrolling certificate's fingerprint, Delegates are fingerprints
+// the owner has authorised to act for the entity (e.g. an NPO case worker).
+type IdentityBinding struct {
+	Owner     string   `json:"owner"`
+	Delegates []string `json:"delegates"`
+}
+
+var any_role = []string{RoleDonor, RoleNPO, RoleRecipient, RoleAdmin}
+
+// MSPs of the organisation operating the network. Other orgs may issue role=admin certificates
+// of their own, so admin-only functions also require one of these MSPs
+var operator_msps = []string{"PrismingMSP"}
+
+var admin_only = AccessRule{Roles: []string{RoleAdmin}, MSPs: operator_msps}
+
+// ============================================================================================================================
+// Access rules - one entry per Invoke function, functions without an entry are refused
+// ============================================================================================================================
+var access_rules = map[string]AccessRule{
+	"query":                  {Roles: any_role},
+	"read_everything":        {Roles: any_role},
+	"get_history":            {Roles: any_role},
+	"enroll_donor":           {Roles: []string{RoleDonor, RoleAdmin}},
+	"enroll_npo":             admin_only,
+	"enroll_recipient":       {Roles: []string{RoleRecipient, RoleNPO, RoleAdmin}},
+	"enroll_needs":           {Roles: []string{RoleNPO, RoleAdmin}},
+	"enroll_initial_needs":   admin_only,
+	"propose_asset":          {Roles: []string{RoleDonor, RoleAdmin}},
+	"approve_asset":          {Roles: []string{RoleNPO, RoleAdmin}},
+	"delete_asset":           {Roles: []string{RoleNPO, RoleAdmin}},
+	"borrow_asset":           {Roles: []string{RoleNPO, RoleRecipient, RoleAdmin}},
+	"give_asset":             {Roles: []string{RoleNPO, RoleAdmin}},
+	"get_back_asset":         {Roles: []string{RoleNPO, RoleAdmin}},
+	"get_identity":           {Roles: any_role},
+	"add_delegate":           {Roles: any_role},
+	"remove_delegate":        {Roles: any_role},
+	"bind_identity":          admin_only,
+	"read_donor_private":     {Roles: []string{RoleDonor, RoleNPO, RoleAdmin}},
+	"read_recipient_private": {Roles: []string{RoleRecipient, RoleNPO, RoleAdmin}},
+	"migrate_keys":           admin_only,
+	"update_donor":           {Roles: []string{RoleDonor, RoleAdmin}},
+	"update_npo":             {Roles: []string{RoleNPO, RoleAdmin}},
+	"update_recipient":       {Roles: []string{RoleRecipient, RoleNPO, RoleAdmin}},
+	"retire_asset":           {Roles: []string{RoleNPO, RoleAdmin}},
+	"get_asset_transitions":  {Roles: any_role},
+	"get_allowed_actions":    {Roles: any_role},
+	"reject_asset":           {Roles: []string{RoleNPO, RoleAdmin}},
+	"withdraw_asset":         {Roles: []string{RoleDonor, RoleAdmin}},
+	"list_assets":            {Roles: any_role},
+	"list_donors":            {Roles: any_role},
+	"list_npos":              {Roles: any_role},
+	"list_recipients":        {Roles: any_role},
+	"list_needs":             {Roles: any_role},
+	"query_assets":           {Roles: any_role},
+	"query_needs":            {Roles: any_role},
+	"rebuild_indexes":        admin_only,
+	"set_match_rule":         {Roles: []string{RoleNPO, RoleAdmin}},
+	"update_need":            {Roles: []string{RoleNPO, RoleAdmin}},
+	"cancel_need":            {Roles: []string{RoleNPO, RoleAdmin}},
+	"close_need":             {Roles: []string{RoleNPO, RoleAdmin}},
+	"sweep_expired_needs":    {Roles: []string{RoleNPO, RoleAdmin}},
+	"renew_loan":             {Roles: []string{RoleNPO, RoleAdmin}},
+	"get_overdue_loans":      {Roles: any_role},
+	"list_loans":             {Roles: any_role},
+	"set_eligibility_policy": {Roles: []string{RoleNPO, RoleAdmin}},
+	"confirm_receipt":        {Roles: []string{RoleRecipient, RoleNPO, RoleAdmin}},
+	"expire_receipt":         {Roles: []string{RoleNPO, RoleAdmin}},
+	"adjust_credit":          admin_only,
+	"redeem_credit":          {Roles: []string{RoleDonor, RoleAdmin}},
+	"get_credit_statement":   {Roles: any_role},
+	"reconcile_credit":       admin_only,
+	"set_credit_policy":      admin_only,
+	"get_credit_policy":      {Roles: any_role},
+	"preview_asset_credit":   {Roles: any_role},
+	"generate_receipt":       {Roles: []string{RoleDonor, RoleAdmin}},
+	"get_receipts":           {Roles: any_role},
+	"verify_receipt":         {Roles: any_role},
+	"get_entity_history":     {Roles: any_role},
+	"get_activity":           {Roles: any_role},
+	"npo_impact_report":      {Roles: []string{RoleNPO, RoleAdmin}},
+	"donor_impact":           {Roles: []string{RoleDonor, RoleAdmin}},
+}
+
+// Read the MSP ID and role attribute of the transaction submitter
+func get_caller_identity(stub shim.ChaincodeStubInterface) (CallerIdentity, error) {
+	var caller CallerIdentity
+
+	mspid, err := cid.GetMSPID(stub)
+	if err != nil {
+		return caller, fmt.Errorf("{\"Error\":\"Failed to get caller MSP ID - %s\"}", err.Error())
+	}
+	caller.MSPID = mspid
+
+	role, found, err := cid.GetAttributeValue(stub, "role")
+	if err != nil {
+		return caller, fmt.Errorf("{\"Error\":\"Failed to get caller role attribute - %s\"}", err.Error())
+	}
+	if !found {
+		return caller, fmt.Errorf("{\"Error\":\"Caller certificate has no role attribute\"}")
+	}
+	caller.Role = role
+
+	fingerprint, err := get_caller_fingerprint(stub)
+	if err != nil {
+		return caller, err
+	}
+	caller.Fingerprint = fingerprint
+
+	return caller, nil
+}
+
+// Fingerprint of the submitter's certificate: sha256 over MSP ID, subject and issuer
+func get_caller_fingerprint(stub shim.ChaincodeStubInterface) (string, error) {
+	mspid, err := cid.GetMSPID(stub)
+	if err != nil {
+		return "", fmt.Errorf("{\"Error\":\"Failed to get caller MSP ID - %s\"}", err.Error())
+	}
+
+	cert, err := cid.GetX509Certificate(stub)
+	if err != nil {
+		return "", fmt.Errorf("{\"Error\":\"Failed to get caller certificate - %s\"}", err.Error())
+	}
+	if cert == nil {
+		return "", fmt.Errorf("{\"Error\":\"Caller has no X.509 certificate\"}")
+	}
+
+	sum := sha256.Sum256([]byte(mspid + "::" + cert.Subject.String() + "::" + cert.Issuer.String()))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// New binding owned by the submitter, used when enrolling an entity
+func new_identity_binding(stub shim.ChaincodeStubInterface) (IdentityBinding, error) {
+	var binding IdentityBinding
+
+	fingerprint, err := get_caller_fingerprint(stub)
+	if err != nil {
+		return binding, err
+	}
+	binding.Owner = fingerprint
+	binding.Delegates = []string{}
+
+	return binding, nil
+}
+
+// Is the submitter an admin of the operator's organisation. Admin certificates of other orgs get no
+// more than the access rules give them
+func is_operator_admin(stub shim.ChaincodeStubInterface) bool {
+	caller, err := get_caller_identity(stub)
+	if err != nil {
+		return false
+	}
+	return caller.Role == RoleAdmin && contains(operator_msps, caller.MSPID)
+}
+
+// Check that the submitter is the bound identity of an entity or one of its delegates.
+// Operator admins act for any entity
+func check_binding(stub shim.ChaincodeStubInterface, binding IdentityBinding, entityId string) error {
+	if is_operator_admin(stub) {
+		return nil
+	}
+	if binding.Owner == "" {
+		return fmt.Errorf("{\"Error\":\"%s is not bound to an identity\"}", entityId)
+	}
+
+	fingerprint, err := get_caller_fingerprint(stub)
+	if err != nil {
+		return err
+	}
+
+	if fingerprint != binding.Owner && !contains(binding.Delegates, fingerprint) {
+		return fmt.Errorf("{\"Error\":\"Caller is not the bound identity or a delegate of %s\"}", entityId)
+	}
+
+	return nil
+}
+
+// check_binding for the Donor, NPO or Recipient stored under id
+func check_entity_binding(stub shim.ChaincodeStubInterface, doctype string, id string) error {
+	entityAsBytes, err := get_state(stub, doctype, id)
+	if err != nil {
+		return fmt.Errorf("{\"Error\":\"Failed to get state for %s\"}", id)
+	}
+	if entityAsBytes == nil {
+		return fmt.Errorf("{\"Error\":\"%s %s does not exist\"}", doctype, id)
+	}
+	var temp_entity struct {
+		IdentityBinding
+	}
+	json.Unmarshal(entityAsBytes, &temp_entity)
+
+	return check_binding(stub, temp_entity.IdentityBinding, id)
+}
+
+// Load the Donor, NPO or Recipient stored under id, let edit change its binding and store it back
+func edit_binding(stub shim.ChaincodeStubInterface, doctype string, id string, edit func(*IdentityBinding) error) error {
+	entityAsBytes, err := get_state(stub, doctype, id)
+	if err != nil {
+		return fmt.Errorf("{\"Error\":\"Failed to get state for %s\"}", id)
+	}
+	if entityAsBytes == nil {
+		return fmt.Errorf("{\"Error\":\"Nil amount for %s\"}", id)
+	}
+
+	var entity interface{}
+	var binding *IdentityBinding
+	var objectType string
+	switch doctype {
+	case "Donor":
+		var temp_donor Donor
+		json.Unmarshal(entityAsBytes, &temp_donor)
+		entity, binding, objectType = &temp_donor, &temp_donor.IdentityBinding, temp_donor.ObjectType
+	case "NPO":
+		var temp_npo NPO
+		json.Unmarshal(entityAsBytes, &temp_npo)
+		entity, binding, objectType = &temp_npo, &temp_npo.IdentityBinding, temp_npo.ObjectType
+	case "Recipient":
+		var temp_rec Recipient
+		json.Unmarshal(entityAsBytes, &temp_rec)
+		entity, binding, objectType = &temp_rec, &temp_rec.IdentityBinding, temp_rec.ObjectType
+	default:
+		return fmt.Errorf("{\"Error\":\"%s entities carry no identity binding\"}", doctype)
+	}
+	if objectType != doctype {
+		return fmt.Errorf("{\"Error\":\"%s is not a %s\"}", id, doctype)
+	}
+
+	err = edit(binding)
+	if err != nil {
+		return err
+	}
+
+	entityAsBytes, _ = json.Marshal(entity)
+
+	return put_state(stub, doctype, id, entityAsBytes)
+}
+
+// ============================================================================================================================
+// get_identity - return the submitter's MSP ID, role and fingerprint, so it can be handed out for delegation
+// ============================================================================================================================
+func (t *SimpleChaincode) get_identity(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	caller, err := get_caller_identity(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	callerAsBytes, _ := json.Marshal(caller)
+	return shim.Success(callerAsBytes)
+}
+
+// ============================================================================================================================
+// add_delegate - args: doctype, entity id, delegate fingerprint. Only the bound identity may add delegates
+// ============================================================================================================================
+func (t *SimpleChaincode) add_delegate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	fingerprint, err := get_caller_fingerprint(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = edit_binding(stub, args[0], args[1], func(binding *IdentityBinding) error {
+		if binding.Owner != fingerprint {
+			return fmt.Errorf("{\"Error\":\"Only the bound identity of %s may add delegates\"}", args[1])
+		}
+		if !contains(binding.Delegates, args[2]) {
+			binding.Delegates = append(binding.Delegates, args[2])
+		}
+		return nil
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emit_event(stub, entity_event("delegate_added", args[0], args[1], args[2]))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================================================================================
+// remove_delegate - args: doctype, entity id, delegate fingerprint. Only the bound identity may remove delegates
+// ============================================================================================================================
+func (t *SimpleChaincode) remove_delegate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	fingerprint, err := get_caller_fingerprint(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = edit_binding(stub, args[0], args[1], func(binding *IdentityBinding) error {
+		if binding.Owner != fingerprint {
+			return fmt.Errorf("{\"Error\":\"Only the bound identity of %s may remove delegates\"}", args[1])
+		}
+		for i, v := range binding.Delegates {
+			if v == args[2] {
+				binding.Delegates = append(binding.Delegates[:i], binding.Delegates[i+1:]...)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emit_event(stub, entity_event("delegate_removed", args[0], args[1], args[2]))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================================================================================
+// bind_identity - args: doctype, entity id, owner fingerprint. Admin rebinding for lost certificates and
+// entities enrolled before identities were recorded
+// ============================================================================================================================
+func (t *SimpleChaincode) bind_identity(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	err := edit_binding(stub, args[0], args[1], func(binding *IdentityBinding) error {
+		binding.Owner = args[2]
+		if binding.Delegates == nil {
+			binding.Delegates = []string{}
+		}
+		return nil
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emit_event(stub, entity_event("identity_bound", args[0], args[1], args[2]))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// Check the submitter against the access rule declared for function
+func check_access(stub shim.ChaincodeStubInterface, function string) error {
+	rule, ok := access_rules[function]
+	if !ok {
+		return fmt.Errorf("{\"Error\":\"No access rule declared for function %s\"}", function)
+	}
+
+	caller, err := get_caller_identity(stub)
+	if err != nil {
+		return err
+	}
+
+	if len(rule.MSPs) > 0 && !contains(rule.MSPs, caller.MSPID) {
+		return fmt.Errorf("{\"Error\":\"MSP %s may not call %s\"}", caller.MSPID, function)
+	}
+	if !contains(rule.Roles, caller.Role) {
+		return fmt.Errorf("{\"Error\":\"Role %s may not call %s\"}", caller.Role, function)
+	}
+
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Copy of list without the first occurrence of value
+func remove_string(list []string, value string) []string {
+	for i, v := range list {
+		if v == value {
+			return append(append([]string{}, list[:i]...), list[i+1:]...)
+		}
+	}
+	return list
+}
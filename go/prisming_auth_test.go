@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestUnknownFunctionIsRefused(t *testing.T) {
+	s := new_mock_stub(t)
+
+	res := s.invoke(operator_admin, "no_such_function")
+	expect_error(t, res, "no_such_function")
+	if !strings.Contains(res.Message, "No access rule") {
+		t.Fatalf("unexpected error - %s", res.Message)
+	}
+}
+
+func TestCallerWithoutRoleIsRefused(t *testing.T) {
+	s := new_mock_stub(t)
+
+	expect_error(t, s.invoke(no_role_user, "get_identity"), "get_identity without a role")
+}
+
+func TestRoleRules(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+
+	res := s.invoke(donor_user, "propose_asset", "a100", "coat", "d100", "n100", "의류", "hash")
+	expect_ok(t, res, "propose_asset as donor")
+
+	res = s.invoke(donor_user, "approve_asset", "a100", "n100")
+	expect_error(t, res, "approve_asset as donor")
+	if !strings.Contains(res.Message, "Role donor may not call approve_asset") {
+		t.Fatalf("unexpected error - %s", res.Message)
+	}
+	expect_error(t, s.invoke(recipient_user, "give_asset", "a100", "n100", "r1"), "give_asset as recipient")
+	expect_error(t, s.invoke(npo_user, "propose_asset", "a101", "coat", "d100", "n100", "의류", "hash"), "propose_asset as npo")
+
+	expect_ok(t, s.invoke(npo_user, "approve_asset", "a100", "n100"), "approve_asset as npo")
+}
+
+func TestAdminOnlyFunctionsNeedTheOperatorMSP(t *testing.T) {
+	s := new_mock_stub(t)
+
+	res := s.invoke(other_admin, "enroll_npo", "n100", "npo one")
+	expect_error(t, res, "enroll_npo as another org's admin")
+	if !strings.Contains(res.Message, "MSP OtherOrgMSP may not call enroll_npo") {
+		t.Fatalf("unexpected error - %s", res.Message)
+	}
+	expect_error(t, s.invoke(other_admin, "bind_identity", "NPO", "n1", other_admin.fingerprint()), "bind_identity as another org's admin")
+	expect_error(t, s.invoke(npo_user, "enroll_npo", "n100", "npo one"), "enroll_npo as npo")
+
+	expect_ok(t, s.invoke(operator_admin, "enroll_npo", "n100", "npo one"), "enroll_npo as the operator's admin")
+
+	// functions open to any admin are not narrowed
+	expect_ok(t, s.invoke(other_admin, "get_identity"), "get_identity as another org's admin")
+}
+
+func TestBoundIdentityAndDelegates(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+
+	expect_error(t, s.invoke(other_donor, "propose_asset", "a100", "coat", "d100", "n100", "의류", "hash"), "propose_asset for another donor")
+	expect_error(t, s.invoke(other_donor, "add_delegate", "Donor", "d100", other_donor.fingerprint()), "add_delegate by a stranger")
+
+	expect_ok(t, s.invoke(donor_user, "add_delegate", "Donor", "d100", other_donor.fingerprint()), "add_delegate")
+	expect_ok(t, s.invoke(other_donor, "propose_asset", "a100", "coat", "d100", "n100", "의류", "hash"), "propose_asset as delegate")
+
+	expect_ok(t, s.invoke(donor_user, "remove_delegate", "Donor", "d100", other_donor.fingerprint()), "remove_delegate")
+	expect_error(t, s.invoke(other_donor, "propose_asset", "a101", "coat", "d100", "n100", "의류", "hash"), "propose_asset after removal")
+}
+
+func TestGetIdentity(t *testing.T) {
+	s := new_mock_stub(t)
+
+	res := s.invoke(npo_user, "get_identity")
+	expect_ok(t, res, "get_identity")
+
+	var caller CallerIdentity
+	json.Unmarshal(res.Payload, &caller)
+	if caller.MSPID != "NPOMSP" || caller.Role != RoleNPO || caller.Fingerprint != npo_user.fingerprint() {
+		t.Fatalf("unexpected identity %+v", caller)
+	}
+}
+
+func TestOnlyOperatorAdminsActForBoundEntities(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+
+	expect_error(t, s.invoke(other_admin, "propose_asset", "a100", "coat", "d100", "n100", "의류", "hash"), "propose_asset as another org's admin")
+	expect_error(t, s.invoke(other_admin, "update_npo", "n100", "taken over"), "update_npo as another org's admin")
+
+	expect_ok(t, s.invoke(operator_admin, "propose_asset", "a100", "coat", "d100", "n100", "의류", "hash"), "propose_asset as the operator's admin")
+	expect_ok(t, s.invoke(operator_admin, "approve_asset", "a100", "n100"), "approve_asset as the operator's admin")
+}
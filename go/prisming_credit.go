@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Kinds of credit journal entries
+const (
+	CreditAward      = "award"      // asset approved against a need
+	CreditAdjustment = "adjustment" // admin correction, either sign
+	CreditRedemption = "redemption" // donor spends credit, always negative
+)
+
+// One movement of a donor's credit. Entries are never changed once written,
+// Donor.Credit is the Balance of the donor's latest entry.
+type CreditEntry struct {
+	ObjectType     string `json:"doctype"`
+	Id             string `json:"id"`
+	DonorId        string `json:"donorid"`
+	Sequence       int    `json:"sequence"` // per donor, from 1, in the order the entries were posted
+	Kind           string `json:"kind"`
+	Amount         int    `json:"amount"`
+	Balance        int    `json:"balance"` // donor credit after the entry
+	Reason         string `json:"reason"`
+	AssetId        string `json:"assetid,omitempty"`
+	NeedId         string `json:"needid,omitempty"`
+	Policy_version int    `json:"policyversion,omitempty"` // credit policy an award was computed with, 0 for the default rule
+	By             string `json:"by"`                      // fingerprint of the submitter
+	Timestamp      string `json:"timestamp"`
+	TxId           string `json:"txId"`
+}
+
+// Journal key: donor and zero-padded sequence, so a donor's entries list in posting order
+func credit_entry_key(stub shim.ChaincodeStubInterface, temp_entry CreditEntry) (string, error) {
+	return stub.CreateCompositeKey("CreditEntry", []string{temp_entry.DonorId, fmt.Sprintf("%010d", temp_entry.Sequence)})
+}
+
+// Write the entries to the journal in order and move the donor's credit and credit sequence with them.
+// The caller stores the donor.
+func post_credit(stub shim.ChaincodeStubInterface, temp_donor *Donor, entries []CreditEntry) error {
+	fingerprint, err := get_caller_fingerprint(stub)
+	if err != nil {
+		return err
+	}
+	txTime, err := get_tx_time(stub)
+	if err != nil {
+		return err
+	}
+	baseId := mint_id(stub, "CreditEntry")
+
+	for i, temp_entry := range entries {
+		temp_entry.ObjectType = "CreditEntry"
+		temp_entry.Id = baseId + "-" + strconv.Itoa(i)
+		temp_entry.DonorId = temp_donor.Id
+		temp_donor.Credit_sequence++
+		temp_entry.Sequence = temp_donor.Credit_sequence
+		temp_entry.By = fingerprint
+		temp_entry.Timestamp = txTime.Format(time.RFC3339)
+		temp_entry.TxId = stub.GetTxID()
+
+		temp_donor.Credit = temp_donor.Credit + temp_entry.Amount
+		temp_entry.Balance = temp_donor.Credit
+
+		key, err := credit_entry_key(stub, temp_entry)
+		if err != nil {
+			return err
+		}
+		EntryAsBytes, _ := json.Marshal(temp_entry)
+
+		err = stub.PutState(key, EntryAsBytes)
+		if err != nil {
+			return err
+		}
+
+		err = emit_event(stub, ChaincodeEvent{
+			Name:    "credit_" + temp_entry.Kind,
+			DonorId: temp_donor.Id,
+			AssetId: temp_entry.AssetId,
+			NeedId:  temp_entry.NeedId,
+			Reason:  strconv.Itoa(temp_entry.Amount) + " - " + temp_entry.Reason,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Every journal entry of the donor, oldest first
+func credit_journal(stub shim.ChaincodeStubInterface, donorId string) ([]CreditEntry, error) {
+	entries := []CreditEntry{}
+
+	entriesIterator, err := stub.GetStateByPartialCompositeKey("CreditEntry", []string{donorId})
+	if err != nil {
+		return nil, err
+	}
+	defer entriesIterator.Close()
+
+	for entriesIterator.HasNext() {
+		aKeyValue, err := entriesIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var temp_entry CreditEntry
+		json.Unmarshal(aKeyValue.Value, &temp_entry)
+		entries = append(entries, temp_entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Sequence < entries[j].Sequence
+	})
+
+	return entries, nil
+}
+
+func get_donor(stub shim.ChaincodeStubInterface, donorId string) (Donor, error) {
+	var temp_donor Donor
+
+	temp_donor_by_byte, err := get_state(stub, "Donor", donorId)
+	if err != nil {
+		return temp_donor, fmt.Errorf("{\"Error\":\"Failed to get donor state\"}")
+	}
+	if temp_donor_by_byte == nil {
+		return temp_donor, fmt.Errorf("{\"Error\":\"Donor %s does not exist\"}", donorId)
+	}
+	json.Unmarshal(temp_donor_by_byte, &temp_donor)
+
+	return temp_donor, nil
+}
+
+func put_donor(stub shim.ChaincodeStubInterface, temp_donor Donor) error {
+	DonorAsBytes, _ := json.Marshal(temp_donor)
+
+	return put_state(stub, "Donor", temp_donor.Id, DonorAsBytes) //store owner by its Id
+}
+
+// ============================================================================================================================
+// adjust_credit - args: donor id, signed amount, reason. Admin correction of a donor's credit
+// ============================================================================================================================
+func (t *SimpleChaincode) adjust_credit(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	amount, err := strconv.Atoi(args[1])
+	if err != nil || amount == 0 {
+		return shim.Error("{\"Error\":\"Amount must be a non-zero number\"}")
+	}
+	if args[2] == "" {
+		return shim.Error("{\"Error\":\"Adjustments need a reason\"}")
+	}
+
+	temp_donor, err := get_donor(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if temp_donor.Credit+amount < 0 {
+		return shim.Error("{\"Error\":\"Adjustment would leave donor " + temp_donor.Id + " with negative credit\"}")
+	}
+
+	err = post_credit(stub, &temp_donor, []CreditEntry{{Kind: CreditAdjustment, Amount: amount, Reason: args[2]}})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = put_donor(stub, temp_donor)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================================================================================
+// redeem_credit - args: donor id, amount, reason. The donor spends credit, never more than the balance
+// ============================================================================================================================
+func (t *SimpleChaincode) redeem_credit(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	amount, err := strconv.Atoi(args[1])
+	if err != nil || amount < 1 {
+		return shim.Error("{\"Error\":\"Amount must be a positive number\"}")
+	}
+
+	temp_donor, err := get_donor(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = check_binding(stub, temp_donor.IdentityBinding, temp_donor.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if amount > temp_donor.Credit {
+		return shim.Error("{\"Error\":\"Donor " + temp_donor.Id + " has " + strconv.Itoa(temp_donor.Credit) + " credit\"}")
+	}
+
+	err = post_credit(stub, &temp_donor, []CreditEntry{{Kind: CreditRedemption, Amount: -amount, Reason: args[2]}})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = put_donor(stub, temp_donor)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================================================================================
+// get_credit_statement - args: donor id, from, to (RFC3339, "" for open ended). Entries in the window with the
+// balances before and after it, for the donor and operator admins
+// ============================================================================================================================
+func (t *SimpleChaincode) get_credit_statement(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	type CreditStatement struct {
+		DonorId        string        `json:"donorid"`
+		From           string        `json:"from"`
+		To             string        `json:"to"`
+		OpeningBalance int           `json:"openingBalance"`
+		ClosingBalance int           `json:"closingBalance"`
+		Awarded        int           `json:"awarded"`
+		Adjusted       int           `json:"adjusted"`
+		Redeemed       int           `json:"redeemed"`
+		Entries        []CreditEntry `json:"entries"`
+	}
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	from, to, err := parse_report_window(args[1], args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	temp_donor, err := get_donor(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = check_binding(stub, temp_donor.IdentityBinding, temp_donor.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	entries, err := credit_journal(stub, temp_donor.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var statement CreditStatement
+	statement.DonorId = temp_donor.Id
+	statement.From = from
+	statement.To = to
+	statement.Entries = []CreditEntry{}
+	if len(entries) > 0 {
+		// credit earned before the journal existed
+		statement.OpeningBalance = entries[0].Balance - entries[0].Amount
+	}
+
+	for _, v := range entries {
+		if from != "" && v.Timestamp < from {
+			statement.OpeningBalance = v.Balance
+			continue
+		}
+		if to != "" && v.Timestamp > to {
+			break
+		}
+		statement.Entries = append(statement.Entries, v)
+		if v.Kind == CreditAward {
+			statement.Awarded = statement.Awarded + v.Amount
+		} else if v.Kind == CreditRedemption {
+			statement.Redeemed = statement.Redeemed - v.Amount
+		} else {
+			statement.Adjusted = statement.Adjusted + v.Amount
+		}
+	}
+	statement.ClosingBalance = statement.OpeningBalance + statement.Awarded + statement.Adjusted - statement.Redeemed
+
+	statementAsBytes, _ := json.Marshal(statement)
+	return shim.Success(statementAsBytes)
+}
+
+// ============================================================================================================================
+// reconcile_credit - args: donor id. Check Donor.Credit against the journal. Credit a donor earned before the
+// journal existed is its opening balance, carried by the Balance of the first entry or, for a donor without
+// entries, booked as an opening adjustment
+// ============================================================================================================================
+func (t *SimpleChaincode) reconcile_credit(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	type Reconciliation struct {
+		DonorId    string `json:"donorid"`
+		Credit     int    `json:"credit"`
+		Opening    int    `json:"opening"` // credit before the first journal entry
+		Journal    int    `json:"journal"` // sum of the journal entries
+		Adjustment int    `json:"adjustment"`
+	}
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	temp_donor, err := get_donor(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	entries, err := credit_journal(stub, temp_donor.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var result Reconciliation
+	result.DonorId = temp_donor.Id
+	result.Credit = temp_donor.Credit
+
+	if len(entries) == 0 {
+		if temp_donor.Credit != 0 {
+			// post_credit adds the amount to Credit, so book it from zero
+			result.Adjustment = temp_donor.Credit
+			temp_donor.Credit = 0
+			err = post_credit(stub, &temp_donor, []CreditEntry{{Kind: CreditAdjustment, Amount: result.Adjustment, Reason: "opening balance, credit earned before the journal"}})
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			err = put_donor(stub, temp_donor)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			result.Journal = result.Adjustment
+		}
+
+		resultAsBytes, _ := json.Marshal(result)
+		return shim.Success(resultAsBytes)
+	}
+
+	result.Opening = entries[0].Balance - entries[0].Amount
+	balance := result.Opening
+	for _, v := range entries {
+		balance = balance + v.Amount
+		if v.Balance != balance {
+			jsonResp := "{\"Error\":\"Credit entry " + v.Id + " has balance " + strconv.Itoa(v.Balance) + ", expected " + strconv.Itoa(balance) + "\"}"
+			return shim.Error(jsonResp)
+		}
+		result.Journal = result.Journal + v.Amount
+	}
+	if balance != temp_donor.Credit {
+		jsonResp := "{\"Error\":\"Donor " + temp_donor.Id + " credit " + strconv.Itoa(temp_donor.Credit) + " does not match journal balance " + strconv.Itoa(balance) + "\"}"
+		return shim.Error(jsonResp)
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How approve_asset turns an approved asset into donor credit. Policies are never changed once
+// written, a new version takes over from its Effective_from date.
+type CreditPolicy struct {
+	ObjectType           string             `json:"doctype"`
+	Version              int                `json:"version"`
+	Effective_from       string             `json:"effectivefrom"`      // RFC3339, never before the tx that set it
+	Matched              float64            `json:"matched"`            // credit per item credited to a need
+	Unmatched            float64            `json:"unmatched"`          // credit per item no open need could take
+	Per_item             bool               `json:"peritem"`            // false: one credit amount per need, whatever the quantity
+	Product_type_weights map[string]float64 `json:"producttypeweights"` // missing product types weigh 1
+	Urgency_weights      map[int]float64    `json:"urgencyweights"`     // by need urgency, missing levels weigh 1
+	Campaigns            []CreditCampaign   `json:"campaigns"`
+	By                   string             `json:"by"` // fingerprint of the submitter
+	TxId                 string             `json:"txId"`
+}
+
+// Promotional multiplier for approvals between From (inclusive) and To (exclusive)
+type CreditCampaign struct {
+	Name          string   `json:"name"`
+	From          string   `json:"from"`
+	To            string   `json:"to"`
+	Multiplier    float64  `json:"multiplier"`
+	Product_types []string `json:"producttypes"` // empty for every product type
+	NPOs          []string `json:"npos"`         // empty for every NPO
+}
+
+// Rule in force before any policy is set: one credit per item credited to a need
+func default_credit_policy() CreditPolicy {
+	return CreditPolicy{ObjectType: "CreditPolicy", Matched: 1, Per_item: true}
+}
+
+// Versions are zero padded so the policies list in version order
+func credit_policy_key(stub shim.ChaincodeStubInterface, version int) (string, error) {
+	return stub.CreateCompositeKey("CreditPolicy", []string{fmt.Sprintf("%08d", version)})
+}
+
+// Every stored policy, oldest version first
+func credit_policies(stub shim.ChaincodeStubInterface) ([]CreditPolicy, error) {
+	policies := []CreditPolicy{}
+
+	policiesIterator, err := stub.GetStateByPartialCompositeKey("CreditPolicy", []string{})
+	if err != nil {
+		return nil, err
+	}
+	defer policiesIterator.Close()
+
+	for policiesIterator.HasNext() {
+		aKeyValue, err := policiesIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		var policy CreditPolicy
+		json.Unmarshal(aKeyValue.Value, &policy)
+		policies = append(policies, policy)
+	}
+
+	return policies, nil
+}
+
+// The policy in force at the given time: the latest effective date not after it, the higher version among equals
+func active_credit_policy(stub shim.ChaincodeStubInterface, at time.Time) (CreditPolicy, error) {
+	active := default_credit_policy()
+
+	policies, err := credit_policies(stub)
+	if err != nil {
+		return active, err
+	}
+	atAsString := at.Format(time.RFC3339)
+	for _, v := range policies {
+		if v.Effective_from <= atAsString && v.Effective_from >= active.Effective_from {
+			active = v
+		}
+	}
+
+	return active, nil
+}
+
+// Credit for quantity items of the asset, urgency is the need's or -1 for items no need took
+func credit_amount(policy CreditPolicy, temp_asset Asset, urgency int, quantity int, at time.Time) int {
+	amount := policy.Matched
+	if urgency < 0 {
+		amount = policy.Unmatched
+	} else if weight, ok := policy.Urgency_weights[urgency]; ok {
+		amount = amount * weight
+	}
+	if weight, ok := policy.Product_type_weights[temp_asset.ProductType]; ok {
+		amount = amount * weight
+	}
+
+	atAsString := at.Format(time.RFC3339)
+	for _, v := range policy.Campaigns {
+		if atAsString < v.From || atAsString >= v.To {
+			continue
+		}
+		if len(v.Product_types) > 0 && !contains(v.Product_types, temp_asset.ProductType) {
+			continue
+		}
+		if len(v.NPOs) > 0 && !contains(v.NPOs, temp_asset.NPOId) {
+			continue
+		}
+		amount = amount * v.Multiplier
+	}
+
+	if policy.Per_item {
+		amount = amount * float64(quantity)
+	}
+	return int(math.Round(amount))
+}
+
+// Award entries for the parts split_asset made of an approved asset, parts without credit are left out
+func credit_awards(policy CreditPolicy, temp_npo NPO, parts []Asset, allocations []NeedAllocation, at time.Time) []CreditEntry {
+	awards := []CreditEntry{}
+
+	for i, part := range parts {
+		urgency := -1
+		reason := "approved by " + temp_npo.Id + " without a matching need"
+		needId := ""
+		if i < len(allocations) {
+			urgency = allocations[i].Need.Urgency
+			reason = "approved by " + temp_npo.Id + " for need " + allocations[i].Need.Name
+			needId = allocations[i].Need.Id
+		}
+
+		amount := credit_amount(policy, part, urgency, asset_quantity(part), at)
+		if amount == 0 {
+			continue
+		}
+		awards = append(awards, CreditEntry{
+			Kind:           CreditAward,
+			Amount:         amount,
+			Reason:         reason,
+			AssetId:        part.Id,
+			NeedId:         needId,
+			Policy_version: policy.Version,
+		})
+	}
+
+	return awards
+}
+
+// Refuse policies with negative rates or malformed campaigns, campaign dates are stored in UTC so they compare as strings
+func check_credit_policy(policy *CreditPolicy) error {
+	if policy.Matched < 0 || policy.Unmatched < 0 {
+		return fmt.Errorf("{\"Error\":\"Credit rates must not be negative\"}")
+	}
+	for k, v := range policy.Product_type_weights {
+		if v < 0 {
+			return fmt.Errorf("{\"Error\":\"Weight of product type %s must not be negative\"}", k)
+		}
+	}
+	for k, v := range policy.Urgency_weights {
+		if v < 0 {
+			return fmt.Errorf("{\"Error\":\"Weight of urgency %d must not be negative\"}", k)
+		}
+	}
+	for i, v := range policy.Campaigns {
+		from, err := time.Parse(time.RFC3339, v.From)
+		if err != nil {
+			return fmt.Errorf("{\"Error\":\"Campaign %s from must be RFC3339\"}", v.Name)
+		}
+		to, err := time.Parse(time.RFC3339, v.To)
+		if err != nil {
+			return fmt.Errorf("{\"Error\":\"Campaign %s to must be RFC3339\"}", v.Name)
+		}
+		if !from.Before(to) {
+			return fmt.Errorf("{\"Error\":\"Campaign %s ends before it starts\"}", v.Name)
+		}
+		if v.Multiplier <= 0 {
+			return fmt.Errorf("{\"Error\":\"Campaign %s multiplier must be positive\"}", v.Name)
+		}
+		policy.Campaigns[i].From = from.UTC().Format(time.RFC3339)
+		policy.Campaigns[i].To = to.UTC().Format(time.RFC3339)
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// set_credit_policy - args: policy JSON {effectivefrom, matched, unmatched, peritem, producttypeweights, urgencyweights,
+// campaigns}. Stores it as the next version, effective from the given date or the tx time when empty
+// ============================================================================================================================
+func (t *SimpleChaincode) set_credit_policy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var policy CreditPolicy
+	decoder := json.NewDecoder(strings.NewReader(args[0]))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&policy)
+	if err != nil {
+		return shim.Error("{\"Error\":\"Invalid policy - " + err.Error() + "\"}")
+	}
+	err = check_credit_policy(&policy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	txTime, err := get_tx_time(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	effective := txTime
+	if policy.Effective_from != "" {
+		effective, err = time.Parse(time.RFC3339, policy.Effective_from)
+		if err != nil {
+			return shim.Error("{\"Error\":\"effectivefrom must be RFC3339\"}")
+		}
+		// approvals already made keep the credit they were given
+		if effective.Before(txTime) {
+			return shim.Error("{\"Error\":\"effectivefrom must not be in the past\"}")
+		}
+	}
+
+	policies, err := credit_policies(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	fingerprint, err := get_caller_fingerprint(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	policy.ObjectType = "CreditPolicy"
+	policy.Version = len(policies) + 1
+	policy.Effective_from = effective.UTC().Format(time.RFC3339)
+	policy.By = fingerprint
+	policy.TxId = stub.GetTxID()
+
+	key, err := credit_policy_key(stub, policy.Version)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	PolicyAsBytes, _ := json.Marshal(policy)
+
+	err = stub.PutState(key, PolicyAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emit_event(stub, ChaincodeEvent{
+		Name:   "credit_policy_set",
+		Reason: "version " + strconv.Itoa(policy.Version) + " effective " + policy.Effective_from,
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte(strconv.Itoa(policy.Version)))
+}
+
+// ============================================================================================================================
+// get_credit_policy - args: optional version. The policy in force at the tx time, or the given version
+// ============================================================================================================================
+func (t *SimpleChaincode) get_credit_policy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) > 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 0 or 1")
+	}
+
+	if len(args) == 1 {
+		version, err := strconv.Atoi(args[0])
+		if err != nil || version < 1 {
+			return shim.Error("{\"Error\":\"Version must be a positive number\"}")
+		}
+		key, err := credit_policy_key(stub, version)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		PolicyAsBytes, err := stub.GetState(key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if PolicyAsBytes == nil {
+			return shim.Error("{\"Error\":\"Credit policy version " + args[0] + " does not exist\"}")
+		}
+		return shim.Success(PolicyAsBytes)
+	}
+
+	txTime, err := get_tx_time(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	policy, err := active_credit_policy(stub, txTime)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	PolicyAsBytes, _ := json.Marshal(policy)
+	return shim.Success(PolicyAsBytes)
+}
+
+// ============================================================================================================================
+// preview_asset_credit - args: asset id. Credit the donor would get if the NPO approved the proposed asset now
+// ============================================================================================================================
+func (t *SimpleChaincode) preview_asset_credit(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	type CreditPreview struct {
+		AssetId       string        `json:"assetid"`
+		PolicyVersion int           `json:"policyversion"`
+		Total         int           `json:"total"`
+		Awards        []CreditEntry `json:"awards"`
+	}
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var temp_asset Asset
+	temp_asset_by_byte, err := get_state(stub, "Asset", args[0])
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get asset state\"}"
+		return shim.Error(jsonResp)
+	}
+	if temp_asset_by_byte == nil {
+		jsonResp := "{\"Error\":\"Nil amount asset state\"}"
+		return shim.Error(jsonResp)
+	}
+	json.Unmarshal(temp_asset_by_byte, &temp_asset)
+
+	_, err = find_transition(temp_asset, "approve")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var temp_npo NPO
+	temp_npo_by_byte, err := get_state(stub, "NPO", temp_asset.NPOId)
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get npo state \"}"
+		return shim.Error(jsonResp)
+	}
+	json.Unmarshal(temp_npo_by_byte, &temp_npo)
+
+	allocations, leftover, err := plan_allocation(stub, temp_npo, temp_asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := get_tx_time(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	policy, err := active_credit_policy(stub, txTime)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var preview CreditPreview
+	preview.AssetId = temp_asset.Id
+	preview.PolicyVersion = policy.Version
+	preview.Awards = credit_awards(policy, temp_npo, split_asset(temp_asset, allocations, leftover), allocations, txTime)
+	for _, v := range preview.Awards {
+		preview.Total = preview.Total + v.Amount
+	}
+
+	previewAsBytes, _ := json.Marshal(preview)
+	return shim.Success(previewAsBytes)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type credit_preview struct {
+	PolicyVersion int           `json:"policyversion"`
+	Total         int           `json:"total"`
+	Awards        []CreditEntry `json:"awards"`
+}
+
+func (s *mock_stub) preview(t *testing.T, assetId string) credit_preview {
+	t.Helper()
+	res := s.invoke(donor_user, "preview_asset_credit", assetId)
+	expect_ok(t, res, "preview_asset_credit")
+	var preview credit_preview
+	json.Unmarshal(res.Payload, &preview)
+	return preview
+}
+
+func (s *mock_stub) active_policy(t *testing.T, args ...string) CreditPolicy {
+	t.Helper()
+	res := s.invoke(npo_user, "get_credit_policy", args...)
+	expect_ok(t, res, "get_credit_policy")
+	var policy CreditPolicy
+	json.Unmarshal(res.Payload, &policy)
+	return policy
+}
+
+func TestCreditPoliciesAreValidated(t *testing.T) {
+	s := new_mock_stub(t)
+
+	if policy := s.active_policy(t); policy.Version != 0 || policy.Matched != 1 || policy.Unmatched != 0 || !policy.Per_item {
+		t.Fatalf("unexpected default policy %+v", policy)
+	}
+
+	past := mock_start.Format(time.RFC3339)
+	expect_error(t, s.invoke(npo_user, "set_credit_policy", `{"matched":2}`), "set_credit_policy by an NPO")
+	expect_error(t, s.invoke(operator_admin, "set_credit_policy", `{"matched":2,"bonus":1}`), "set_credit_policy with an unknown field")
+	expect_error(t, s.invoke(operator_admin, "set_credit_policy", `{"matched":-1}`), "set_credit_policy with a negative rate")
+	expect_error(t, s.invoke(operator_admin, "set_credit_policy", `{"matched":2,"effectivefrom":"`+past+`"}`), "set_credit_policy effective in the past")
+	expect_error(t, s.invoke(operator_admin, "set_credit_policy",
+		`{"matched":2,"campaigns":[{"name":"spring","from":"2026-04-01T00:00:00Z","to":"2026-03-01T00:00:00Z","multiplier":2}]}`), "set_credit_policy with a campaign ending before it starts")
+	expect_error(t, s.invoke(operator_admin, "set_credit_policy",
+		`{"matched":2,"campaigns":[{"name":"spring","from":"2026-03-01T00:00:00Z","to":"2026-04-01T00:00:00Z","multiplier":0}]}`), "set_credit_policy with a zero multiplier")
+
+	res := s.invoke(operator_admin, "set_credit_policy", `{"matched":2}`)
+	expect_ok(t, res, "set_credit_policy")
+	if string(res.Payload) != "1" {
+		t.Fatalf("unexpected version %s", res.Payload)
+	}
+	if policy := s.active_policy(t); policy.Version != 1 || policy.Matched != 2 || policy.By != operator_admin.fingerprint() {
+		t.Fatalf("unexpected active policy %+v", policy)
+	}
+	expect_error(t, s.invoke(npo_user, "get_credit_policy", "2"), "get_credit_policy of a missing version")
+}
+
+func TestApprovalFollowsTheCreditPolicy(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+	expect_ok(t, s.invoke(npo_user, "enroll_needs", "e100", "n100", "셔츠", "의류", "2", "3"), "enroll_needs")
+	expect_ok(t, s.invoke(operator_admin, "set_credit_policy",
+		`{"matched":2,"unmatched":0.5,"peritem":true,"producttypeweights":{"식품":0.5},"urgencyweights":{"3":2}}`), "set_credit_policy version 1")
+
+	// a campaign tripling clothes credit the next two days, under a policy taking over tomorrow
+	tomorrow := mock_start.AddDate(0, 0, 1)
+	expect_ok(t, s.invoke(operator_admin, "set_credit_policy", `{"matched":1,"unmatched":1,"peritem":true,"effectivefrom":"`+tomorrow.Format(time.RFC3339)+
+		`","campaigns":[{"name":"winter","from":"`+tomorrow.Format(time.RFC3339)+`","to":"`+tomorrow.AddDate(0, 0, 2).Format(time.RFC3339)+
+		`","multiplier":3,"producttypes":["의류"]}]}`), "set_credit_policy version 2")
+	if policy := s.active_policy(t); policy.Version != 1 {
+		t.Fatalf("version %d in force before its date", policy.Version)
+	}
+	if policy := s.active_policy(t, "2"); len(policy.Campaigns) != 1 || policy.Campaigns[0].Name != "winter" {
+		t.Fatalf("unexpected version 2 %+v", policy)
+	}
+
+	// 2 items for the urgency 3 need at 2 x 2, 3 leftover items at 0.5 rounded
+	expect_ok(t, s.invoke(donor_user, "propose_asset", "a100", "셔츠", "d100", "n100", "의류", "hash", "", "5"), "propose_asset a100")
+	preview := s.preview(t, "a100")
+	if preview.PolicyVersion != 1 || preview.Total != 10 || len(preview.Awards) != 2 || preview.Awards[0].Amount != 8 || preview.Awards[1].Amount != 2 {
+		t.Fatalf("unexpected preview %+v", preview)
+	}
+	expect_ok(t, s.invoke(npo_user, "approve_asset", "a100", "n100"), "approve_asset a100")
+	var temp_donor Donor
+	s.read(t, "Donor", "d100", &temp_donor)
+	if temp_donor.Credit != preview.Total {
+		t.Fatalf("donor credited %d, previewed %d", temp_donor.Credit, preview.Total)
+	}
+	expect_error(t, s.invoke(donor_user, "preview_asset_credit", "a100"), "preview_asset_credit of an approved asset")
+
+	s.txCount += 2 * 24 * 60
+	expect_ok(t, s.invoke(donor_user, "propose_asset", "a101", "셔츠", "d100", "n100", "의류", "hash"), "propose_asset a101")
+	expect_ok(t, s.invoke(donor_user, "propose_asset", "a102", "라면", "d100", "n100", "식품", "hash"), "propose_asset a102")
+	if preview = s.preview(t, "a101"); preview.PolicyVersion != 2 || preview.Total != 3 {
+		t.Fatalf("unexpected campaign preview %+v", preview)
+	}
+	if preview = s.preview(t, "a102"); preview.Total != 1 {
+		t.Fatalf("campaign applied outside its product types - %+v", preview)
+	}
+	expect_ok(t, s.invoke(npo_user, "approve_asset", "a101", "n100"), "approve_asset a101")
+	statement := s.statement(t, donor_user, "d100", "", "")
+	last := statement.Entries[len(statement.Entries)-1]
+	if last.Amount != 3 || last.Policy_version != 2 || statement.Entries[0].Policy_version != 1 {
+		t.Fatalf("awards not stamped with their policy - %+v", statement.Entries)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+type credit_statement struct {
+	OpeningBalance int           `json:"openingBalance"`
+	ClosingBalance int           `json:"closingBalance"`
+	Awarded        int           `json:"awarded"`
+	Adjusted       int           `json:"adjusted"`
+	Redeemed       int           `json:"redeemed"`
+	Entries        []CreditEntry `json:"entries"`
+}
+
+func (s *mock_stub) statement(t *testing.T, caller test_identity, args ...string) credit_statement {
+	t.Helper()
+	res := s.invoke(caller, "get_credit_statement", args...)
+	expect_ok(t, res, "get_credit_statement")
+	var statement credit_statement
+	json.Unmarshal(res.Payload, &statement)
+	return statement
+}
+
+// d100 awarded 3 for a100, adjusted by 2 and redeeming 4
+func new_credit_stub(t *testing.T) *mock_stub {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+	expect_ok(t, s.invoke(npo_user, "enroll_needs", "e100", "n100", "셔츠", "의류", "10"), "enroll_needs")
+	s.donate(t, "a100", "의류", "", "3")
+	expect_ok(t, s.invoke(operator_admin, "adjust_credit", "d100", "2", "volunteer day"), "adjust_credit")
+	expect_ok(t, s.invoke(donor_user, "redeem_credit", "d100", "4", "mug"), "redeem_credit")
+	return s
+}
+
+func TestCreditIsJournaled(t *testing.T) {
+	s := new_credit_stub(t)
+
+	var temp_donor Donor
+	s.read(t, "Donor", "d100", &temp_donor)
+	if temp_donor.Credit != 1 || temp_donor.Credit_sequence != 3 {
+		t.Fatalf("unexpected donor credit %+v", temp_donor)
+	}
+
+	statement := s.statement(t, donor_user, "d100", "", "")
+	if statement.OpeningBalance != 0 || statement.Awarded != 3 || statement.Adjusted != 2 || statement.Redeemed != 4 || statement.ClosingBalance != 1 {
+		t.Fatalf("unexpected totals %+v", statement)
+	}
+	kinds := []string{}
+	for i, v := range statement.Entries {
+		if v.Sequence != i+1 || v.DonorId != "d100" || v.TxId == "" || v.Timestamp == "" {
+			t.Fatalf("unexpected entry %+v", v)
+		}
+		kinds = append(kinds, v.Kind)
+	}
+	expect_ids(t, kinds, CreditAward, CreditAdjustment, CreditRedemption)
+	award := statement.Entries[0]
+	if award.Amount != 3 || award.Balance != 3 || award.AssetId != "a100" || award.NeedId != "e100" || award.By != npo_user.fingerprint() {
+		t.Fatalf("unexpected award %+v", award)
+	}
+	if statement.Entries[2].Amount != -4 || statement.Entries[2].Reason != "mug" || statement.Entries[2].Balance != 1 {
+		t.Fatalf("unexpected redemption %+v", statement.Entries[2])
+	}
+
+	// the window starts at the adjustment, the award is in the opening balance
+	windowed := s.statement(t, operator_admin, "d100", statement.Entries[1].Timestamp, "")
+	if windowed.OpeningBalance != 3 || windowed.Awarded != 0 || len(windowed.Entries) != 2 || windowed.ClosingBalance != 1 {
+		t.Fatalf("unexpected windowed statement %+v", windowed)
+	}
+	expect_error(t, s.invoke(donor_user, "get_credit_statement", "d100", "last year", ""), "get_credit_statement with a malformed window")
+	expect_error(t, s.invoke(other_donor, "get_credit_statement", "d100", "", ""), "get_credit_statement of another donor")
+	expect_error(t, s.invoke(npo_user, "get_credit_statement", "d100", "", ""), "get_credit_statement by an NPO")
+}
+
+func TestCreditNeverGoesNegative(t *testing.T) {
+	s := new_credit_stub(t)
+
+	expect_error(t, s.invoke(donor_user, "adjust_credit", "d100", "5", "self service"), "adjust_credit by the donor")
+	expect_error(t, s.invoke(operator_admin, "adjust_credit", "d100", "5", ""), "adjust_credit without a reason")
+	expect_error(t, s.invoke(operator_admin, "adjust_credit", "d100", "-2", "typo"), "adjust_credit below zero")
+	expect_error(t, s.invoke(other_donor, "redeem_credit", "d100", "1", "mug"), "redeem_credit for another donor")
+	expect_error(t, s.invoke(donor_user, "redeem_credit", "d100", "0", "nothing"), "redeem_credit of nothing")
+	res := s.invoke(donor_user, "redeem_credit", "d100", "2", "mug")
+	expect_error(t, res, "redeem_credit past the balance")
+	if !strings.Contains(res.Message, "has 1 credit") {
+		t.Fatalf("unexpected error %s", res.Message)
+	}
+	if statement := s.statement(t, donor_user, "d100", "", ""); len(statement.Entries) != 3 {
+		t.Fatalf("refused movements journaled - %+v", statement.Entries)
+	}
+}
+
+func TestReconcileCreditAgainstTheJournal(t *testing.T) {
+	s := new_credit_stub(t)
+
+	expect_error(t, s.invoke(donor_user, "reconcile_credit", "d100"), "reconcile_credit by the donor")
+	res := s.invoke(operator_admin, "reconcile_credit", "d100")
+	expect_ok(t, res, "reconcile_credit")
+	if string(res.Payload) != `{"donorid":"d100","credit":1,"opening":0,"journal":1,"adjustment":0}` {
+		t.Fatalf("unexpected reconciliation %s", res.Payload)
+	}
+
+	// credit earned before the journal is booked as the opening balance
+	key, _ := s.CreateCompositeKey("Donor", []string{"d9"})
+	s.put_legacy(t, key, `{"doctype":"Donor","id":"d9","name":"old donor","credit":3}`)
+	res = s.invoke(operator_admin, "reconcile_credit", "d9")
+	expect_ok(t, res, "reconcile_credit of a donor without a journal")
+	if !strings.Contains(string(res.Payload), `"adjustment":3`) {
+		t.Fatalf("unexpected reconciliation %s", res.Payload)
+	}
+	statement := s.statement(t, operator_admin, "d9", "", "")
+	if len(statement.Entries) != 1 || statement.Entries[0].Kind != CreditAdjustment || statement.ClosingBalance != 3 {
+		t.Fatalf("opening balance not journaled - %+v", statement)
+	}
+	expect_ok(t, s.invoke(operator_admin, "reconcile_credit", "d9"), "reconcile_credit after the opening balance")
+
+	// credit changed outside the journal
+	var temp_donor Donor
+	s.read(t, "Donor", "d100", &temp_donor)
+	temp_donor.Credit = 7
+	donorAsBytes, _ := json.Marshal(temp_donor)
+	key, _ = s.CreateCompositeKey("Donor", []string{"d100"})
+	s.put_legacy(t, key, string(donorAsBytes))
+	res = s.invoke(operator_admin, "reconcile_credit", "d100")
+	expect_error(t, res, "reconcile_credit of a tampered donor")
+	if !strings.Contains(res.Message, "does not match journal balance 1") {
+		t.Fatalf("unexpected error %s", res.Message)
+	}
+}
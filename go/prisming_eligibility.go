@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"strings"
+	"time"
+)
+
+// Policy key applying to recipients whose type has no policy of its own
+const AnyRecipientType = "*"
+
+// What recipients of one type may take from an NPO. The type stays in the PII collection, so eligibility is
+// checked on peers of the collection's orgs for callers that may read the recipient's private details.
+// Empty lists allow anything, zero limits are no limit.
+type EligibilityPolicy struct {
+	Can_borrow            bool     `json:"canborrow"`
+	Can_receive           bool     `json:"canreceive"` // given outright
+	Statuses              []string `json:"statuses"`   // asset statuses the recipient may take from
+	Borrow_product_types  []string `json:"borrowproducttypes"`
+	Receive_product_types []string `json:"receiveproducttypes"`
+	Max_loans             int      `json:"maxloans"` // active loans at once
+	Max_items             int      `json:"maxitems"` // items borrowed or received within Period_days
+	Period_days           int      `json:"perioddays"`
+}
+
+// The NPO's policy for the recipient type, falling back to AnyRecipientType
+func find_policy(temp_npo NPO, recipientType string) (EligibilityPolicy, bool) {
+	policy, ok := temp_npo.Eligibility[recipientType]
+	if !ok {
+		policy, ok = temp_npo.Eligibility[AnyRecipientType]
+	}
+	return policy, ok
+}
+
+// Active loans of the recipient
+func count_active_loans(stub shim.ChaincodeStubInterface, recipientId string) (int, error) {
+	loans, err := recipient_loans(stub, recipientId)
+	if err != nil {
+		return 0, err
+	}
+	active := 0
+	for _, v := range loans {
+		if v.Status == LoanActive {
+			active++
+		}
+	}
+	return active, nil
+}
+
+// Every loan of the recipient, through the Loan~recipientid index
+func recipient_loans(stub shim.ChaincodeStubInterface, recipientId string) ([]Loan, error) {
+	loans := []Loan{}
+
+	loansIterator, err := stub.GetStateByPartialCompositeKey("Loan~recipientid", []string{recipientId})
+	if err != nil {
+		return nil, err
+	}
+	defer loansIterator.Close()
+
+	for loansIterator.HasNext() {
+		aKeyValue, err := loansIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(aKeyValue.Key)
+		if err != nil || len(keyParts) != 2 {
+			continue
+		}
+		temp_loan, err := get_loan(stub, keyParts[1])
+		if err != nil {
+			return nil, err
+		}
+		loans = append(loans, temp_loan)
+	}
+
+	return loans, nil
+}
+
+// Items the recipient borrowed or was given since the given time
+func count_items_since(stub shim.ChaincodeStubInterface, temp_rec Recipient, since string) (int, error) {
+	items := 0
+
+	loans, err := recipient_loans(stub, temp_rec.Id)
+	if err != nil {
+		return 0, err
+	}
+	for _, v := range loans {
+		if v.Borrowed < since {
+			continue
+		}
+		temp_asset_by_byte, err := get_state(stub, "Asset", v.AssetId)
+		if err != nil {
+			return 0, err
+		}
+		var temp_asset Asset
+		json.Unmarshal(temp_asset_by_byte, &temp_asset)
+		items = items + asset_quantity(temp_asset)
+	}
+
+	// given assets stay in the recipient's asset array
+	for _, v := range temp_rec.Asset_array {
+		temp_asset_by_byte, err := get_state(stub, "Asset", v)
+		if err != nil {
+			return 0, err
+		}
+		if temp_asset_by_byte == nil {
+			continue
+		}
+		var temp_asset Asset
+		json.Unmarshal(temp_asset_by_byte, &temp_asset)
+		for _, change := range temp_asset.Status_history {
+			if change.Action == "give" && change.Timestamp >= since {
+				items = items + asset_quantity(temp_asset)
+				break
+			}
+		}
+	}
+
+	return items, nil
+}
+
+// Type of the recipient from its private details. The caller must be allowed to read them, and the peer must
+// hold them, otherwise eligibility cannot be checked and the request is refused
+func recipient_type_for_eligibility(stub shim.ChaincodeStubInterface, recipientId string) (string, error) {
+	err := check_private_reader(stub, "Recipient", recipientId)
+	if err != nil {
+		return "", fmt.Errorf("{\"Error\":\"Eligibility of recipient %s needs its private details, which the caller may not read\"}", recipientId)
+	}
+	temp_private, err := get_recipient_private(stub, recipientId)
+	if err != nil {
+		return "", fmt.Errorf("{\"Error\":\"Eligibility of recipient %s needs its private details, this peer does not hold them\"}", recipientId)
+	}
+	return temp_private.Types, nil
+}
+
+// Error explaining why the recipient may not borrow or receive ("give") the asset, nil when allowed.
+// NPOs without any policy put no restriction on their recipients.
+func check_eligibility(stub shim.ChaincodeStubInterface, temp_asset Asset, temp_rec Recipient, action string) error {
+	var temp_npo NPO
+	temp_npo_by_byte, err := get_state(stub, "NPO", temp_asset.NPOId)
+	if err != nil {
+		return fmt.Errorf("{\"Error\":\"Failed to get npo state \"}")
+	}
+	json.Unmarshal(temp_npo_by_byte, &temp_npo)
+	if len(temp_npo.Eligibility) == 0 {
+		return nil
+	}
+
+	recipientType, err := recipient_type_for_eligibility(stub, temp_rec.Id)
+	if err != nil {
+		return err
+	}
+	typeAsString := recipientType
+	if recipientType == "" {
+		typeAsString = "no type"
+	}
+
+	denied := func(reason string) error {
+		return fmt.Errorf("{\"Error\":\"Recipient %s (%s) may not %s asset %s from %s - %s\"}", temp_rec.Id, typeAsString, action, temp_asset.Id, temp_npo.Id, reason)
+	}
+
+	policy, ok := find_policy(temp_npo, recipientType)
+	if !ok {
+		return denied("the NPO has no policy for this type and no \"" + AnyRecipientType + "\" policy")
+	}
+
+	productTypes := policy.Receive_product_types
+	if action == "borrow" {
+		if !policy.Can_borrow {
+			return denied("borrowing is not allowed")
+		}
+		productTypes = policy.Borrow_product_types
+	} else if !policy.Can_receive {
+		return denied("receiving outright is not allowed")
+	}
+	if len(productTypes) > 0 && !contains(productTypes, temp_asset.ProductType) {
+		return denied("product type " + temp_asset.ProductType + " is not one of " + strings.Join(productTypes, ", "))
+	}
+	if len(policy.Statuses) > 0 && !contains(policy.Statuses, temp_asset.Status) {
+		return denied("asset status " + temp_asset.Status + " is not one of " + strings.Join(policy.Statuses, ", "))
+	}
+
+	if action == "borrow" && policy.Max_loans > 0 {
+		active, err := count_active_loans(stub, temp_rec.Id)
+		if err != nil {
+			return err
+		}
+		if active >= policy.Max_loans {
+			return denied(fmt.Sprintf("%d active loans, the limit is %d", active, policy.Max_loans))
+		}
+	}
+
+	if policy.Max_items > 0 && policy.Period_days > 0 {
+		txTime, err := get_tx_time(stub)
+		if err != nil {
+			return err
+		}
+		since := txTime.AddDate(0, 0, -policy.Period_days).Format(time.RFC3339)
+		items, err := count_items_since(stub, temp_rec, since)
+		if err != nil {
+			return err
+		}
+		if items+asset_quantity(temp_asset) > policy.Max_items {
+			return denied(fmt.Sprintf("%d items taken in the last %d days, the limit is %d", items, policy.Period_days, policy.Max_items))
+		}
+	}
+
+	return nil
+}
+
+// ============================================================================================================================
+// set_eligibility_policy - args: npo id, recipient type (or "*"), policy JSON. An empty policy removes the type's policy
+// ============================================================================================================================
+func (t *SimpleChaincode) set_eligibility_policy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	if args[1] == "" {
+		return shim.Error("{\"Error\":\"Recipient type must not be empty\"}")
+	}
+
+	var temp_npo NPO
+	temp_npo_by_byte, err := get_state(stub, "NPO", args[0])
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get npo state\"}"
+		return shim.Error(jsonResp)
+	}
+	if temp_npo_by_byte == nil {
+		jsonResp := "{\"Error\":\"NPO " + args[0] + " does not exist\"}"
+		return shim.Error(jsonResp)
+	}
+	json.Unmarshal(temp_npo_by_byte, &temp_npo)
+
+	err = check_binding(stub, temp_npo.IdentityBinding, temp_npo.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if temp_npo.Eligibility == nil {
+		temp_npo.Eligibility = map[string]EligibilityPolicy{}
+	}
+	if args[2] == "" {
+		delete(temp_npo.Eligibility, args[1])
+	} else {
+		var policy EligibilityPolicy
+		decoder := json.NewDecoder(strings.NewReader(args[2]))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&policy)
+		if err != nil {
+			return shim.Error("{\"Error\":\"Invalid policy - " + err.Error() + "\"}")
+		}
+		if policy.Max_loans < 0 || policy.Max_items < 0 || policy.Period_days < 0 {
+			return shim.Error("{\"Error\":\"Policy limits must not be negative\"}")
+		}
+		if policy.Max_items > 0 && policy.Period_days == 0 {
+			return shim.Error("{\"Error\":\"maxitems needs perioddays\"}")
+		}
+		temp_npo.Eligibility[args[1]] = policy
+	}
+
+	NPOAsBytes, _ := json.Marshal(temp_npo)
+
+	err = put_state(stub, "NPO", temp_npo.Id, NPOAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emit_event(stub, ChaincodeEvent{Name: "eligibility_policy_set", NPOId: temp_npo.Id, Reason: args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
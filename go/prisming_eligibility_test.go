@@ -0,0 +1,99 @@
+package main
+
+import (
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"strings"
+	"testing"
+)
+
+func expect_denied(t *testing.T, res pb.Response, what string, reason string) {
+	t.Helper()
+	expect_error(t, res, what)
+	if !strings.Contains(res.Message, reason) {
+		t.Fatalf("%s - expected %q, got %s", what, reason, res.Message)
+	}
+}
+
+// a100 to a102 clothes and a103 food approved by n100, r100 a Temporary recipient and r101 a Permanent one
+func new_eligibility_stub(t *testing.T) *mock_stub {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+	expect_ok(t, s.invoke_transient(recipient_user, map[string]interface{}{"recipient": RecipientPrivate{Name: "홍길동", Types: "Temporary", Salt: "s1"}},
+		"enroll_recipient", "r100"), "enroll_recipient r100")
+	expect_ok(t, s.invoke_transient(other_recip, map[string]interface{}{"recipient": RecipientPrivate{Name: "김철수", Types: "Permanent", Salt: "s2"}},
+		"enroll_recipient", "r101"), "enroll_recipient r101")
+	s.donate(t, "a100", "의류")
+	s.donate(t, "a101", "의류")
+	s.donate(t, "a102", "의류")
+	s.donate(t, "a103", "식품")
+	return s
+}
+
+func TestEligibilityPoliciesAreValidated(t *testing.T) {
+	s := new_eligibility_stub(t)
+
+	expect_error(t, s.invoke(donor_user, "set_eligibility_policy", "n100", "Temporary", `{"canborrow":true}`), "set_eligibility_policy as a donor")
+	expect_error(t, s.invoke(npo_user, "set_eligibility_policy", "n1", "Temporary", `{"canborrow":true}`), "set_eligibility_policy for another NPO")
+	expect_error(t, s.invoke(npo_user, "set_eligibility_policy", "n100", "", `{"canborrow":true}`), "set_eligibility_policy without a type")
+	expect_denied(t, s.invoke(npo_user, "set_eligibility_policy", "n100", "Temporary", `{"canlend":true}`), "set_eligibility_policy with an unknown field", "Invalid policy")
+	expect_denied(t, s.invoke(npo_user, "set_eligibility_policy", "n100", "Temporary", `{"maxloans":-1}`), "set_eligibility_policy with a negative limit", "must not be negative")
+	expect_denied(t, s.invoke(npo_user, "set_eligibility_policy", "n100", "Temporary", `{"maxitems":3}`), "set_eligibility_policy without a period", "maxitems needs perioddays")
+
+	expect_ok(t, s.invoke(npo_user, "set_eligibility_policy", "n100", "Temporary", `{"canborrow":true,"maxloans":1}`), "set_eligibility_policy")
+	var temp_npo NPO
+	s.read(t, "NPO", "n100", &temp_npo)
+	if policy, ok := temp_npo.Eligibility["Temporary"]; !ok || !policy.Can_borrow || policy.Max_loans != 1 {
+		t.Fatalf("unexpected policies %+v", temp_npo.Eligibility)
+	}
+
+	// an empty policy removes the type's, and an NPO without policies restricts nobody
+	expect_ok(t, s.invoke(npo_user, "set_eligibility_policy", "n100", "Temporary", ""), "set_eligibility_policy removing the policy")
+	var cleared NPO
+	s.read(t, "NPO", "n100", &cleared)
+	if len(cleared.Eligibility) != 0 {
+		t.Fatalf("policy not removed - %+v", cleared.Eligibility)
+	}
+	expect_ok(t, s.invoke(recipient_user, "borrow_asset", "a103", "r100"), "borrow_asset without policies")
+}
+
+func TestEligibilityFollowsTheRecipientType(t *testing.T) {
+	s := new_eligibility_stub(t)
+	expect_ok(t, s.invoke(npo_user, "set_eligibility_policy", "n100", "Temporary",
+		`{"canborrow":true,"borrowproducttypes":["의류"],"maxloans":1}`), "set_eligibility_policy Temporary")
+
+	expect_denied(t, s.invoke(recipient_user, "borrow_asset", "a103", "r100"), "borrow_asset of food",
+		"Recipient r100 (Temporary) may not borrow asset a103 from n100 - product type 식품 is not one of 의류")
+	expect_ok(t, s.invoke(recipient_user, "borrow_asset", "a100", "r100"), "borrow_asset")
+	expect_denied(t, s.invoke(recipient_user, "borrow_asset", "a101", "r100"), "borrow_asset past the loan limit",
+		"1 active loans, the limit is 1")
+
+	// no policy for the type and no "*" policy
+	expect_denied(t, s.invoke(other_recip, "borrow_asset", "a101", "r101"), "borrow_asset without a policy for the type",
+		"(Permanent) may not borrow asset a101 from n100 - the NPO has no policy for this type")
+
+	// the NPO giving outright must be able to read the recipient's type
+	expect_ok(t, s.invoke(npo_user, "set_eligibility_policy", "n100", AnyRecipientType, `{"canreceive":true}`), "set_eligibility_policy *")
+	expect_denied(t, s.invoke(npo_user, "give_asset", "a101", "r100"), "give_asset without reading the recipient",
+		"which the caller may not read")
+	expect_ok(t, s.invoke(recipient_user, "add_delegate", "Recipient", "r100", npo_user.fingerprint()), "add_delegate r100")
+	expect_ok(t, s.invoke(other_recip, "add_delegate", "Recipient", "r101", npo_user.fingerprint()), "add_delegate r101")
+	expect_denied(t, s.invoke(npo_user, "give_asset", "a101", "r100"), "give_asset to a Temporary recipient",
+		"receiving outright is not allowed")
+	expect_ok(t, s.invoke(npo_user, "give_asset", "a101", "r101"), "give_asset under the * policy")
+	expect_denied(t, s.invoke(other_recip, "borrow_asset", "a102", "r101"), "borrow_asset under the * policy",
+		"borrowing is not allowed")
+}
+
+func TestEligibilityLimitsItemsPerPeriod(t *testing.T) {
+	s := new_eligibility_stub(t)
+	expect_ok(t, s.invoke(npo_user, "set_eligibility_policy", "n100", "Temporary",
+		`{"canborrow":true,"statuses":["Approved"],"maxitems":1,"perioddays":30}`), "set_eligibility_policy")
+
+	expect_ok(t, s.invoke(recipient_user, "borrow_asset", "a100", "r100"), "borrow_asset")
+	expect_ok(t, s.invoke(npo_user, "get_back_asset", "a100", "r100"), "get_back_asset")
+	expect_denied(t, s.invoke(recipient_user, "borrow_asset", "a101", "r100"), "borrow_asset within the period",
+		"1 items taken in the last 30 days, the limit is 1")
+
+	s.txCount += 31 * 24 * 60
+	expect_ok(t, s.invoke(recipient_user, "borrow_asset", "a101", "r100"), "borrow_asset after the period")
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"time"
+)
+
+// Fabric keeps one event per transaction, every change of a tx goes into this envelope
+const EventEnvelopeName = "PrismingEvents"
+
+// One state change, as delivered to client apps
+type ChaincodeEvent struct {
+	Name        string `json:"name"` // asset_proposed, asset_approved, need_completed, ...
+	AssetId     string `json:"assetId,omitempty"`
+	DonorId     string `json:"donorId,omitempty"`
+	NPOId       string `json:"npoId,omitempty"`
+	RecipientId string `json:"recipientId,omitempty"`
+	NeedId      string `json:"needId,omitempty"`
+	OldStatus   string `json:"oldStatus"`
+	NewStatus   string `json:"newStatus"`
+	Reason      string `json:"reason,omitempty"`
+	TxId        string `json:"txId"`
+	Timestamp   string `json:"timestamp"` // tx time, RFC3339
+}
+
+type EventEnvelope struct {
+	TxId   string           `json:"txId"`
+	Events []ChaincodeEvent `json:"events"`
+}
+
+// Stub handed down by Init and Invoke, carrying the events the invocation emitted so far
+type invocation_stub struct {
+	shim.ChaincodeStubInterface
+	events []ChaincodeEvent
+}
+
+func new_invocation_stub(stub shim.ChaincodeStubInterface) *invocation_stub {
+	return &invocation_stub{ChaincodeStubInterface: stub, events: []ChaincodeEvent{}}
+}
+
+// Add event to the transaction's envelope and set the envelope as the tx event, and record it in the activity index.
+// Block events reach every client of the channel, so the envelope leaves the recipient out. The activity index
+// keeps it for get_activity, which shows it to the readers recipient_view allows
+func emit_event(stub shim.ChaincodeStubInterface, event ChaincodeEvent) error {
+	invocation, ok := stub.(*invocation_stub)
+	if !ok {
+		return fmt.Errorf("{\"Error\":\"Events can only be emitted through the stub of Init or Invoke\"}")
+	}
+	txId := stub.GetTxID()
+	event.TxId = txId
+	txTime, err := get_tx_time(stub)
+	if err != nil {
+		return err
+	}
+	event.Timestamp = txTime.Format(time.RFC3339)
+
+	public_event := event
+	public_event.RecipientId = ""
+	invocation.events = append(invocation.events, public_event)
+
+	err = put_activity(stub, event, txTime, len(invocation.events)-1)
+	if err != nil {
+		return err
+	}
+
+	envelopeAsBytes, _ := json.Marshal(EventEnvelope{TxId: txId, Events: invocation.events})
+	return stub.SetEvent(EventEnvelopeName, envelopeAsBytes)
+}
+
+// Event about a Donor, NPO or Recipient, with the id in the doctype's field
+func entity_event(name string, doctype string, id string, reason string) ChaincodeEvent {
+	event := ChaincodeEvent{Name: name, Reason: reason}
+	switch doctype {
+	case "Donor":
+		event.DonorId = id
+	case "NPO":
+		event.NPOId = id
+	case "Recipient":
+		event.RecipientId = id
+	}
+	return event
+}
+
+// Event for an asset moving from oldStatus to its current status
+func asset_event(name string, temp_asset Asset, oldStatus string) ChaincodeEvent {
+	return ChaincodeEvent{
+		Name:      name,
+		AssetId:   temp_asset.Id,
+		DonorId:   temp_asset.DonorId,
+		NPOId:     temp_asset.NPOId,
+		OldStatus: oldStatus,
+		NewStatus: temp_asset.Status,
+	}
+}
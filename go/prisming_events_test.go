@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Envelope of the last transaction's chaincode event
+func (s *mock_stub) envelope(t *testing.T) EventEnvelope {
+	t.Helper()
+	var envelope EventEnvelope
+	if s.event == nil {
+		t.Fatal("no chaincode event set")
+	}
+	err := json.Unmarshal(s.event, &envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return envelope
+}
+
+func TestStateChangesEmitEvents(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+
+	expect_ok(t, s.invoke(donor_user, "propose_asset", "a100", "coat", "d100", "n100", "의류", "hash"), "propose_asset")
+	envelope := s.envelope(t)
+	if len(envelope.Events) != 1 {
+		t.Fatalf("unexpected events %+v", envelope.Events)
+	}
+	proposed := envelope.Events[0]
+	if proposed.Name != "asset_proposed" || proposed.AssetId != "a100" || proposed.DonorId != "d100" || proposed.NPOId != "n100" ||
+		proposed.OldStatus != "" || proposed.NewStatus != StatusProposed {
+		t.Fatalf("unexpected event %+v", proposed)
+	}
+	if proposed.TxId != envelope.TxId || proposed.TxId != "tx0005" || proposed.Timestamp != "2026-03-02T09:05:00Z" {
+		t.Fatalf("event not stamped with its tx - %+v", proposed)
+	}
+
+	expect_ok(t, s.invoke(npo_user, "reject_asset", "a100", "n100", "no coats"), "reject_asset")
+	rejected := s.envelope(t).Events[0]
+	if rejected.Name != "asset_rejected" || rejected.OldStatus != StatusProposed || rejected.NewStatus != StatusRejected || rejected.Reason != "no coats" {
+		t.Fatalf("unexpected event %+v", rejected)
+	}
+
+	// a refused transaction sets no event
+	expect_error(t, s.invoke(npo_user, "approve_asset", "a100", "n100"), "approve_asset of a rejected asset")
+	if s.event != nil {
+		t.Fatalf("refused transaction set an event %s", s.event)
+	}
+}
+
+func TestOneEnvelopePerTransaction(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+	expect_ok(t, s.invoke(npo_user, "enroll_needs", "e100", "n100", "coats", "의류", "1"), "enroll_needs")
+	expect_ok(t, s.invoke(donor_user, "propose_asset", "a100", "coat", "d100", "n100", "의류", "hash"), "propose_asset")
+
+	expect_ok(t, s.invoke(npo_user, "approve_asset", "a100", "n100"), "approve_asset")
+	names := []string{}
+	for _, v := range s.envelope(t).Events {
+		names = append(names, v.Name)
+	}
+	expect_ids(t, names, "asset_approved", "need_completed", "credit_award")
+}
+
+func TestEventsNeedTheInvocationStub(t *testing.T) {
+	s := new_mock_stub(t)
+
+	s.MockTransactionStart("direct")
+	err := emit_event(s, ChaincodeEvent{Name: "asset_proposed"})
+	s.MockTransactionEnd("direct")
+	if err == nil {
+		t.Fatal("event emitted outside Init and Invoke")
+	}
+}
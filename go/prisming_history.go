@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Key histories read so far in one query, so joining many versions reads each related key once
+type history_cache map[string][]*queryresult.KeyModification
+
+// Tx time of a key modification
+func modification_time(mod *queryresult.KeyModification) time.Time {
+	if mod.Timestamp == nil {
+		return time.Time{}
+	}
+	return time.Unix(mod.Timestamp.Seconds, int64(mod.Timestamp.Nanos)).UTC()
+}
+
+// Every modification of the entity's key, oldest first. The peer returns them in commit order, they are
+// sorted by timestamp anyway so consumers do not depend on it
+func key_history(stub shim.ChaincodeStubInterface, cache history_cache, doctype string, id string) ([]*queryresult.KeyModification, error) {
+	key, err := entity_key(stub, doctype, id)
+	if err != nil {
+		return nil, err
+	}
+	if mods, ok := cache[key]; ok {
+		return mods, nil
+	}
+
+	resultsIterator, err := stub.GetHistoryForKey(key)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	mods := []*queryresult.KeyModification{}
+	for resultsIterator.HasNext() {
+		mod, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		mods = append(mods, mod)
+	}
+	sort.SliceStable(mods, func(i, j int) bool {
+		return modification_time(mods[i]).Before(modification_time(mods[j]))
+	})
+	cache[key] = mods
+
+	return mods, nil
+}
+
+// Value of the entity as it was once transaction txId at time at committed, nil when it did not exist then
+// or had been deleted
+func entity_as_of(stub shim.ChaincodeStubInterface, cache history_cache, doctype string, id string, txId string, at time.Time) ([]byte, error) {
+	if id == "" {
+		return nil, nil
+	}
+	mods, err := key_history(stub, cache, doctype, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var best *queryresult.KeyModification
+	for _, mod := range mods {
+		// the same transaction wrote both keys
+		if mod.TxId == txId {
+			best = mod
+			break
+		}
+		modTime := modification_time(mod)
+		if modTime.After(at) {
+			continue
+		}
+		if best == nil || !modTime.Before(modification_time(best)) {
+			best = mod
+		}
+	}
+
+	if best == nil || best.IsDelete {
+		return nil, nil
+	}
+	return best.Value, nil
+}
+
+// One version of an entity in get_entity_history
+type HistoryRecord struct {
+	TxId      string      `json:"txId"`
+	Timestamp string      `json:"timestamp"`
+	IsDelete  bool        `json:"isDelete"`
+	Value     interface{} `json:"value"` // the doctype's struct, nil for a delete
+}
+
+// Decode a stored value into the struct of its doctype
+func decode_entity(doctype string, valueAsBytes []byte) (interface{}, error) {
+	var entity interface{}
+	switch doctype {
+	case "Asset":
+		entity = &Asset{}
+	case "Donor":
+		entity = &Donor{}
+	case "NPO":
+		entity = &NPO{}
+	case "Recipient":
+		entity = &Recipient{}
+	case "Need":
+		entity = &Need{}
+	case "Loan":
+		entity = &Loan{}
+	case "Receipt":
+		entity = &Receipt{}
+	default:
+		return nil, fmt.Errorf("{\"Error\":\"Unknown doctype %s\"}", doctype)
+	}
+
+	err := json.Unmarshal(valueAsBytes, entity)
+	if err != nil {
+		return nil, err
+	}
+	return entity, nil
+}
+
+// Doctype of the id, from the keys that have a history. Deleted entities are found too
+func detect_doctype(stub shim.ChaincodeStubInterface, cache history_cache, id string) (string, error) {
+	found := []string{}
+	for _, doctype := range entity_doctypes {
+		mods, err := key_history(stub, cache, doctype, id)
+		if err != nil {
+			return "", err
+		}
+		if len(mods) > 0 {
+			found = append(found, doctype)
+		}
+	}
+
+	if len(found) == 0 {
+		return "", fmt.Errorf("{\"Error\":\"%s has no history\"}", id)
+	}
+	if len(found) > 1 {
+		return "", fmt.Errorf("{\"Error\":\"%s exists for several doctypes (%s), query with doctype and id\"}", id, strings.Join(found, ", "))
+	}
+	return found[0], nil
+}
+
+// ============================================================================================================================
+// get_entity_history - args: doctype ("" to detect it), id, from, to (RFC3339, "" for open ended), page size,
+// optional bookmark. Versions of any entity in the time window, decoded into its doctype's struct
+// ============================================================================================================================
+func (t *SimpleChaincode) get_entity_history(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	type EntityHistory struct {
+		Doctype  string          `json:"doctype"`
+		Id       string          `json:"id"`
+		Records  []HistoryRecord `json:"records"`
+		Bookmark string          `json:"bookmark"` // "" on the last page
+	}
+
+	if len(args) < 5 || len(args) > 6 {
+		return shim.Error("Incorrect number of arguments. Expecting doctype, id, from, to, page size and optional bookmark")
+	}
+
+	var from, to time.Time
+	var err error
+	if args[2] != "" {
+		from, err = time.Parse(time.RFC3339, args[2])
+		if err != nil {
+			return shim.Error("{\"Error\":\"Dates must be RFC3339 - " + args[2] + "\"}")
+		}
+	}
+	if args[3] != "" {
+		to, err = time.Parse(time.RFC3339, args[3])
+		if err != nil {
+			return shim.Error("{\"Error\":\"Dates must be RFC3339 - " + args[3] + "\"}")
+		}
+	}
+	pageSize, bookmark, err := parse_page_args(args[4:])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// the history iterator cannot resume, the bookmark is the position in the filtered versions
+	skip := 0
+	if bookmark != "" {
+		skip, err = strconv.Atoi(bookmark)
+		if err != nil || skip < 0 {
+			return shim.Error("{\"Error\":\"Invalid bookmark\"}")
+		}
+	}
+
+	cache := history_cache{}
+	doctype := args[0]
+	if doctype == "" {
+		doctype, err = detect_doctype(stub, cache, args[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	} else if !contains(entity_doctypes, doctype) {
+		return shim.Error("{\"Error\":\"Unknown doctype " + doctype + "\"}")
+	}
+
+	mods, err := key_history(stub, cache, doctype, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var result EntityHistory
+	result.Doctype = doctype
+	result.Id = args[1]
+	result.Records = []HistoryRecord{}
+	view := new_recipient_view(stub)
+
+	position := 0
+	for _, mod := range mods {
+		modTime := modification_time(mod)
+		if args[2] != "" && modTime.Before(from) {
+			continue
+		}
+		if args[3] != "" && modTime.After(to) {
+			continue
+		}
+		position++
+		if position <= skip {
+			continue
+		}
+		if len(result.Records) == int(pageSize) {
+			result.Bookmark = strconv.Itoa(position - 1)
+			break
+		}
+
+		var record HistoryRecord
+		record.TxId = mod.TxId
+		record.Timestamp = modTime.Format(time.RFC3339)
+		record.IsDelete = mod.IsDelete
+		if !mod.IsDelete {
+			record.Value, err = decode_entity(doctype, mod.Value)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			view.redact_entity(record.Value)
+		}
+		result.Records = append(result.Records, record)
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
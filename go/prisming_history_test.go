@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type audit_entry struct {
+	TxId           string     `json:"txId"`
+	Timestamp      string     `json:"timestamp"`
+	IsDelete       bool       `json:"isDelete"`
+	Value          Asset      `json:"value"`
+	Donor_info     *Donor     `json:"Donor_info"`
+	Npo_info       *NPO       `json:"Npo_info"`
+	Recipient_info *Recipient `json:"Recipient_info"`
+	Missing        []string   `json:"missing"`
+}
+
+func (s *mock_stub) audit(t *testing.T, caller test_identity, assetId string) []audit_entry {
+	t.Helper()
+	res := s.invoke(caller, "get_history", assetId)
+	expect_ok(t, res, "get_history")
+	var history []audit_entry
+	json.Unmarshal(res.Payload, &history)
+	return history
+}
+
+// Delete the entity's key in a transaction of its own, as a removal outside the chaincode's functions would
+func (s *mock_stub) remove(t *testing.T, doctype string, id string) {
+	t.Helper()
+	s.txCount++
+	txId := fmt.Sprintf("tx%04d", s.txCount)
+	key, _ := s.CreateCompositeKey(doctype, []string{id})
+	s.MockTransactionStart(txId)
+	s.TxTimestamp.Seconds = mock_start.Add(time.Duration(s.txCount) * time.Minute).Unix()
+	err := s.DelState(key)
+	s.MockTransactionEnd(txId)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHistoryJoinsEntitiesAsTheyWere(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+	expect_ok(t, s.invoke(npo_user, "enroll_needs", "e100", "n100", "셔츠", "의류", "5"), "enroll_needs")
+	expect_ok(t, s.invoke(donor_user, "propose_asset", "a100", "coat", "d100", "n100", "의류", "hash"), "propose_asset")
+	expect_ok(t, s.invoke(donor_user, "update_donor", "d100", "donor renamed"), "update_donor")
+	expect_ok(t, s.invoke(npo_user, "approve_asset", "a100", "n100"), "approve_asset")
+
+	history := s.audit(t, donor_user, "a100")
+	if len(history) != 2 {
+		t.Fatalf("%d history entries, expected 2", len(history))
+	}
+	proposed, approved := history[0], history[1]
+	if proposed.Value.Status != StatusProposed || proposed.Timestamp != "2026-03-02T09:06:00Z" || proposed.IsDelete {
+		t.Fatalf("unexpected first entry %+v", proposed)
+	}
+	if proposed.Donor_info == nil || proposed.Donor_info.Name != "donor one" || proposed.Donor_info.Credit != 0 || proposed.Npo_info == nil {
+		t.Fatalf("first entry not joined with the donor of its time - %+v", proposed.Donor_info)
+	}
+	// the award of the approving transaction is in its donor
+	if approved.Value.Status != StatusApproved || approved.Donor_info == nil || approved.Donor_info.Name != "donor renamed" || approved.Donor_info.Credit != 1 {
+		t.Fatalf("second entry not joined with the donor of its time - %+v", approved.Donor_info)
+	}
+	if proposed.TxId >= approved.TxId {
+		t.Fatalf("history not oldest first - %s, %s", proposed.TxId, approved.TxId)
+	}
+}
+
+func TestHistoryOutlivesRelatedRecords(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+	expect_ok(t, s.invoke(donor_user, "propose_asset", "a100", "coat", "d100", "n100", "의류", "hash"), "propose_asset a100")
+	expect_ok(t, s.invoke(npo_user, "delete_asset", "a100", "n100"), "delete_asset")
+	expect_ok(t, s.invoke(donor_user, "propose_asset", "a101", "scarf", "d100", "n100", "의류", "hash"), "propose_asset a101")
+
+	// a deleted asset keeps its history, the delete joined with its last version's entities
+	history := s.audit(t, donor_user, "a100")
+	if len(history) != 2 || !history[1].IsDelete || history[1].Donor_info == nil || history[1].Npo_info == nil {
+		t.Fatalf("unexpected history of a deleted asset %+v", history)
+	}
+
+	// without a need the approval does not touch the donor, which is gone by then
+	s.remove(t, "Donor", "d100")
+	expect_ok(t, s.invoke(npo_user, "approve_asset", "a101", "n100"), "approve_asset")
+	history = s.audit(t, npo_user, "a101")
+	if len(history) != 2 || history[0].Donor_info == nil || len(history[0].Missing) != 0 {
+		t.Fatalf("unexpected first entry %+v", history[0])
+	}
+	if history[1].Donor_info != nil || len(history[1].Missing) != 1 || history[1].Missing[0] != "Donor d100" || history[1].Npo_info == nil {
+		t.Fatalf("removed donor not reported missing - %+v", history[1])
+	}
+}
+
+type entity_history struct {
+	Doctype string `json:"doctype"`
+	Records []struct {
+		TxId      string          `json:"txId"`
+		Timestamp string          `json:"timestamp"`
+		IsDelete  bool            `json:"isDelete"`
+		Value     json.RawMessage `json:"value"`
+	} `json:"records"`
+	Bookmark string `json:"bookmark"`
+}
+
+func (s *mock_stub) entity_history(t *testing.T, caller test_identity, args ...string) entity_history {
+	t.Helper()
+	res := s.invoke(caller, "get_entity_history", args...)
+	expect_ok(t, res, "get_entity_history")
+	var history entity_history
+	json.Unmarshal(res.Payload, &history)
+	return history
+}
+
+func TestEveryDoctypeHasAHistory(t *testing.T) {
+	s := new_approved_stub(t)
+	expect_ok(t, s.invoke(npo_user, "enroll_needs", "e100", "n100", "셔츠", "의류", "5"), "enroll_needs")
+	expect_ok(t, s.invoke(npo_user, "update_need", "e100", "n100", "반팔 셔츠", "의류", "6"), "update_need")
+	expect_ok(t, s.invoke(donor_user, "update_donor", "d100", "donor renamed"), "update_donor")
+
+	// the doctype is detected from the id, every version decoded into its struct
+	history := s.entity_history(t, donor_user, "", "d100", "", "", "10")
+	if history.Doctype != "Donor" || len(history.Records) < 3 {
+		t.Fatalf("unexpected donor history %+v", history)
+	}
+	var first, last Donor
+	json.Unmarshal(history.Records[0].Value, &first)
+	json.Unmarshal(history.Records[len(history.Records)-1].Value, &last)
+	if first.Name != "donor one" || last.Name != "donor renamed" || last.Credit != 0 {
+		t.Fatalf("unexpected donor versions %+v, %+v", first, last)
+	}
+
+	history = s.entity_history(t, npo_user, "Need", "e100", "", "", "10")
+	if len(history.Records) != 2 {
+		t.Fatalf("unexpected need history %+v", history)
+	}
+	var updated Need
+	json.Unmarshal(history.Records[1].Value, &updated)
+	if updated.Name != "반팔 셔츠" || updated.Total_count != 6 {
+		t.Fatalf("unexpected need version %+v", updated)
+	}
+
+	for _, doctype := range []string{"NPO", "Recipient", "Asset"} {
+		id := map[string]string{"NPO": "n100", "Recipient": "r100", "Asset": "a100"}[doctype]
+		if history = s.entity_history(t, operator_admin, "", id, "", "", "10"); history.Doctype != doctype || len(history.Records) == 0 {
+			t.Fatalf("unexpected %s history %+v", doctype, history)
+		}
+	}
+
+	// a recipient record is whole only for its own identity
+	history = s.entity_history(t, donor_user, "Recipient", "r100", "", "", "10")
+	var temp_rec Recipient
+	json.Unmarshal(history.Records[0].Value, &temp_rec)
+	if temp_rec.Owner != "" {
+		t.Fatalf("recipient binding shown to a donor - %+v", temp_rec)
+	}
+}
+
+func TestEntityHistoryPagesAndWindows(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+	for _, name := range []string{"one", "two", "three"} {
+		expect_ok(t, s.invoke(donor_user, "update_donor", "d100", "donor "+name), "update_donor")
+	}
+
+	// four versions: enrolled at tx2, renamed at tx5 to tx7
+	page := s.entity_history(t, donor_user, "Donor", "d100", "", "", "3")
+	if len(page.Records) != 3 || page.Bookmark == "" {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+	next := s.entity_history(t, donor_user, "Donor", "d100", "", "", "3", page.Bookmark)
+	if len(next.Records) != 1 || next.Bookmark != "" || next.Records[0].TxId != "tx0007" {
+		t.Fatalf("unexpected last page %+v", next)
+	}
+
+	window := s.entity_history(t, donor_user, "Donor", "d100", "2026-03-02T09:05:00Z", "2026-03-02T09:06:00Z", "10")
+	txIds := []string{}
+	for _, v := range window.Records {
+		txIds = append(txIds, v.TxId)
+	}
+	expect_ids(t, txIds, "tx0005", "tx0006")
+
+	expect_error(t, s.invoke(donor_user, "get_entity_history", "Donor", "d100", "yesterday", "", "10"), "get_entity_history with a malformed date")
+	expect_error(t, s.invoke(donor_user, "get_entity_history", "Donor", "d100", "", "", "10", "-1"), "get_entity_history with a malformed bookmark")
+	expect_error(t, s.invoke(donor_user, "get_entity_history", "Wallet", "d100", "", "", "10"), "get_entity_history of an unknown doctype")
+	expect_error(t, s.invoke(donor_user, "get_entity_history", "", "x404", "", "", "10"), "get_entity_history of an unknown id")
+
+	// the same id under two doctypes needs the doctype
+	expect_ok(t, s.invoke(operator_admin, "enroll_npo", "d100", "npo named like a donor"), "enroll_npo d100")
+	expect_error(t, s.invoke(donor_user, "get_entity_history", "", "d100", "", "", "10"), "get_entity_history of an ambiguous id")
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"time"
+)
+
+// Outcome of a donated asset as shown to its donor, by asset status
+var asset_outcomes = map[string]string{
+	StatusProposed:       "in review",
+	StatusApproved:       "in stock",
+	StatusBorrowed:       "lent",
+	StatusPendingReceipt: "awaiting receipt",
+	StatusGiven:          "given",
+	StatusRejected:       "not accepted",
+	StatusWithdrawn:      "withdrawn",
+	StatusRetired:        "retired",
+}
+
+// UndisclosedType is shown when the recipient's type cannot be read on this peer
+const UndisclosedType = "undisclosed"
+
+// Public view of an OwnerRelation. Usernames and recipient ids never leave the chaincode,
+// recipients are only shown by their type
+type RedactedOwner struct {
+	User_type      string `json:"user_type"`
+	Id             string `json:"id,omitempty"` // donors and NPOs only
+	Recipient_type string `json:"recipient_type,omitempty"`
+}
+
+// Recipient type from the private collection, cached per recipient id
+func recipient_type(stub shim.ChaincodeStubInterface, types map[string]string, recipientId string) string {
+	if recipientId == "" {
+		return UndisclosedType
+	}
+	if v, ok := types[recipientId]; ok {
+		return v
+	}
+
+	recipientType := UndisclosedType
+	temp_private, err := get_recipient_private(stub, recipientId)
+	if err == nil && temp_private.Types != "" {
+		recipientType = temp_private.Types
+	}
+	types[recipientId] = recipientType
+
+	return recipientType
+}
+
+// The only way an OwnerRelation is shown outside the ledger. Relations written before recipient details
+// moved to the private collection carry the recipient's name in Username and its type in User_type
+func redact_owner(stub shim.ChaincodeStubInterface, types map[string]string, relation OwnerRelation) RedactedOwner {
+	var redacted RedactedOwner
+	switch relation.User_type {
+	case "Donor", "NPO":
+		redacted.User_type = relation.User_type
+		redacted.Id = relation.Id
+	case "Recipient":
+		redacted.User_type = "Recipient"
+		redacted.Recipient_type = recipient_type(stub, types, relation.Id)
+	default:
+		redacted.User_type = "Recipient"
+		redacted.Recipient_type = relation.User_type
+		if redacted.Recipient_type == "" {
+			redacted.Recipient_type = UndisclosedType
+		}
+	}
+	return redacted
+}
+
+// One change of the asset's status or holder, read from the asset's key history
+type ImpactStep struct {
+	Action    string         `json:"action"` // "" for changes made before status history was recorded
+	Status    string         `json:"status"`
+	Timestamp string         `json:"timestamp"`
+	TxId      string         `json:"txId"`
+	Holder    *RedactedOwner `json:"holder,omitempty"` // set when the asset changed hands
+}
+
+type AssetImpact struct {
+	AssetId        string       `json:"assetid"`
+	Name           string       `json:"name"`
+	ProductType    string       `json:"producttype"`
+	Quantity       int          `json:"quantity"`
+	Unit           string       `json:"unit"`
+	NPOId          string       `json:"npoid"`
+	Status         string       `json:"status"`
+	Outcome        string       `json:"outcome"`
+	Times_lent     int          `json:"timeslent"`
+	Given          string       `json:"given"`          // RFC3339, "" unless given
+	Recipient_type string       `json:"recipient_type"` // type of the recipient it was given to
+	Steps          []ImpactStep `json:"steps"`
+}
+
+// Action of the status change the transaction txId recorded, "" when there is none
+func action_of_tx(history []StatusChange, txId string) string {
+	action := ""
+	for _, v := range history {
+		if v.TxId == txId {
+			action = v.Action
+		}
+	}
+	return action
+}
+
+// Trace of one asset, from every version of its key. Assets older than status history and loans are
+// followed through their status and Owner_history versions alone
+func trace_asset(stub shim.ChaincodeStubInterface, cache history_cache, types map[string]string, temp_asset Asset) (AssetImpact, error) {
+	var impact AssetImpact
+	impact.AssetId = temp_asset.Id
+	impact.Name = temp_asset.Name
+	impact.ProductType = temp_asset.ProductType
+	impact.Quantity = asset_quantity(temp_asset)
+	impact.Unit = temp_asset.Unit
+	impact.NPOId = temp_asset.NPOId
+	impact.Status = temp_asset.Status
+	impact.Outcome = asset_outcomes[temp_asset.Status]
+	impact.Steps = []ImpactStep{}
+
+	mods, err := key_history(stub, cache, "Asset", temp_asset.Id)
+	if err != nil {
+		return impact, err
+	}
+	prevStatus := ""
+	prevOwners := 0
+	givenStep := ""
+	for _, mod := range mods {
+		if mod.IsDelete {
+			continue
+		}
+		var version Asset
+		json.Unmarshal(mod.Value, &version)
+		if version.Status == prevStatus && len(version.Owner_history) == prevOwners {
+			continue
+		}
+
+		var step ImpactStep
+		step.Action = action_of_tx(version.Status_history, mod.TxId)
+		step.Status = version.Status
+		step.Timestamp = modification_time(mod).Format(time.RFC3339)
+		step.TxId = mod.TxId
+		if len(version.Owner_history) > prevOwners {
+			holder := redact_owner(stub, types, version.Owner_history[len(version.Owner_history)-1])
+			step.Holder = &holder
+		}
+		if version.Status != prevStatus {
+			if version.Status == StatusBorrowed {
+				impact.Times_lent++
+			}
+			if version.Status == StatusGiven {
+				givenStep = step.Timestamp
+			}
+		}
+		impact.Steps = append(impact.Steps, step)
+
+		prevStatus = version.Status
+		prevOwners = len(version.Owner_history)
+	}
+
+	if temp_asset.Status == StatusGiven {
+		impact.Given = given_time(temp_asset)
+		if impact.Given == "" {
+			impact.Given = givenStep
+		}
+		if temp_asset.RecipientId != "" {
+			impact.Recipient_type = recipient_type(stub, types, temp_asset.RecipientId)
+		} else if len(temp_asset.Owner_history) > 0 {
+			impact.Recipient_type = redact_owner(stub, types, temp_asset.Owner_history[len(temp_asset.Owner_history)-1]).Recipient_type
+		}
+	}
+
+	return impact, nil
+}
+
+// ============================================================================================================================
+// donor_impact - args: donor id. Where each of the donor's assets ended up, recipients are shown by type only.
+// For the donor and operator admins
+// ============================================================================================================================
+func (t *SimpleChaincode) donor_impact(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	type DonorImpact struct {
+		DonorId string        `json:"donorid"`
+		Given   int           `json:"given"`   // assets given
+		Lent    int           `json:"lent"`    // loans of the assets, past and current
+		InStock int           `json:"instock"` // assets approved and not lent or given
+		Assets  []AssetImpact `json:"assets"`
+	}
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	temp_donor, err := get_donor(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = check_binding(stub, temp_donor.IdentityBinding, temp_donor.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var result DonorImpact
+	result.DonorId = temp_donor.Id
+	result.Assets = []AssetImpact{}
+
+	cache := history_cache{}
+	types := map[string]string{}
+	for _, v := range temp_donor.Assets_array {
+		temp_asset_by_byte, err := get_state(stub, "Asset", v)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if temp_asset_by_byte == nil {
+			continue
+		}
+		var temp_asset Asset
+		json.Unmarshal(temp_asset_by_byte, &temp_asset)
+
+		impact, err := trace_asset(stub, cache, types, temp_asset)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		switch temp_asset.Status {
+		case StatusGiven:
+			result.Given++
+		case StatusApproved:
+			result.InStock++
+		}
+		result.Lent = result.Lent + impact.Times_lent
+		result.Assets = append(result.Assets, impact)
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
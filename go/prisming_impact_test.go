@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDonorImpactFollowsAssetsToRecipientTypes(t *testing.T) {
+	s := new_approved_stub(t)
+	expect_ok(t, s.invoke(donor_user, "propose_asset", "a101", "scarf", "d100", "n100", "의류", "hash"), "propose_asset")
+	expect_ok(t, s.invoke(recipient_user, "borrow_asset", "a100", "r100"), "borrow_asset")
+	expect_ok(t, s.invoke(npo_user, "get_back_asset", "a100", "r100"), "get_back_asset")
+	expect_ok(t, s.invoke(npo_user, "give_asset", "a100", "r100"), "give_asset")
+	expect_ok(t, s.invoke(recipient_user, "confirm_receipt", "a100"), "confirm_receipt")
+
+	res := s.invoke(donor_user, "donor_impact", "d100")
+	expect_ok(t, res, "donor_impact")
+	for _, v := range []string{"r100", "홍길동", recipient_user.fingerprint()} {
+		if strings.Contains(string(res.Payload), v) {
+			t.Fatalf("recipient %s shown to the donor - %s", v, res.Payload)
+		}
+	}
+	var result struct {
+		Given   int           `json:"given"`
+		Lent    int           `json:"lent"`
+		InStock int           `json:"instock"`
+		Assets  []AssetImpact `json:"assets"`
+	}
+	json.Unmarshal(res.Payload, &result)
+	if result.Given != 1 || result.Lent != 1 || result.InStock != 0 || len(result.Assets) != 2 {
+		t.Fatalf("unexpected impact %s", res.Payload)
+	}
+
+	given := result.Assets[0]
+	if given.Outcome != "given" || given.Times_lent != 1 || given.Recipient_type != "Temporary" || given.Given == "" {
+		t.Fatalf("unexpected trace %+v", given)
+	}
+	actions := []string{}
+	holders := []string{}
+	for _, v := range given.Steps {
+		actions = append(actions, v.Action)
+		if v.Holder != nil {
+			holders = append(holders, v.Holder.User_type+" "+v.Holder.Id+v.Holder.Recipient_type)
+		}
+	}
+	expect_ids(t, actions, "propose", "approve", "borrow", "return", "give", "confirm_receipt")
+	expect_ids(t, holders, "Recipient Temporary", "NPO n100", "Recipient Temporary")
+	if result.Assets[1].Outcome != "in review" || len(result.Assets[1].Steps) != 1 {
+		t.Fatalf("unexpected trace %+v", result.Assets[1])
+	}
+
+	expect_error(t, s.invoke(other_donor, "donor_impact", "d100"), "donor_impact of another donor")
+	expect_error(t, s.invoke(npo_user, "donor_impact", "d100"), "donor_impact by an NPO")
+	expect_error(t, s.invoke(other_admin, "donor_impact", "d100"), "donor_impact by another org's admin")
+	expect_ok(t, s.invoke(operator_admin, "donor_impact", "d100"), "donor_impact by the operator's admin")
+}
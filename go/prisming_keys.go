@@ -0,0 +1,466 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"strconv"
+)
+
+// Doctypes stored on the ledger, each under its own composite key namespace
+var entity_doctypes = []string{"Asset", "Donor", "NPO", "Recipient", "Need", "Loan", "Receipt"}
+
+// Prefixes of minted ids, matching the ids clients have been choosing by hand
+var id_prefixes = map[string]string{
+	"Asset":       "a",
+	"Donor":       "d",
+	"NPO":         "n",
+	"Recipient":   "r",
+	"Need":        "e",
+	"Loan":        "l",
+	"CreditEntry": "c",
+}
+
+// Id for a new entity derived from the tx ID and doctype, so every endorser mints the same one
+func mint_id(stub shim.ChaincodeStubInterface, doctype string) string {
+	sum := sha256.Sum256([]byte(stub.GetTxID() + "~" + doctype))
+	return id_prefixes[doctype] + hex.EncodeToString(sum[:8])
+}
+
+// The caller's id when one was passed, a minted one when it is empty
+func resolve_id(stub shim.ChaincodeStubInterface, doctype string, id string) string {
+	if id == "" {
+		return mint_id(stub, doctype)
+	}
+	return id
+}
+
+// Composite key of an entity: doctype + id
+func entity_key(stub shim.ChaincodeStubInterface, doctype string, id string) (string, error) {
+	if id == "" {
+		return "", fmt.Errorf("{\"Error\":\"Empty %s id\"}", doctype)
+	}
+	return stub.CreateCompositeKey(doctype, []string{id})
+}
+
+// GetState for the entity of doctype with id, refusing values stored as another doctype
+func get_state(stub shim.ChaincodeStubInterface, doctype string, id string) ([]byte, error) {
+	key, err := entity_key(stub, doctype, id)
+	if err != nil {
+		return nil, err
+	}
+
+	valueAsBytes, err := stub.GetState(key)
+	if err != nil || valueAsBytes == nil {
+		return valueAsBytes, err
+	}
+
+	var header struct {
+		ObjectType string `json:"doctype"`
+	}
+	json.Unmarshal(valueAsBytes, &header)
+	if header.ObjectType != doctype {
+		return nil, fmt.Errorf("{\"Error\":\"%s is stored as %s, not %s\"}", id, header.ObjectType, doctype)
+	}
+
+	return valueAsBytes, nil
+}
+
+// Error when an entity of doctype with id is already on the ledger, used by every create function
+func check_not_exists(stub shim.ChaincodeStubInterface, doctype string, id string) error {
+	valueAsBytes, err := get_state(stub, doctype, id)
+	if err != nil {
+		return err
+	}
+	if valueAsBytes != nil {
+		return fmt.Errorf("{\"Error\":\"%s %s already exists\"}", doctype, id)
+	}
+	return nil
+}
+
+// PutState for the entity of doctype with id, keeping its secondary indexes in step
+func put_state(stub shim.ChaincodeStubInterface, doctype string, id string, valueAsBytes []byte) error {
+	key, err := entity_key(stub, doctype, id)
+	if err != nil {
+		return err
+	}
+
+	oldAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return err
+	}
+	err = update_indexes(stub, doctype, id, oldAsBytes, valueAsBytes)
+	if err != nil {
+		return err
+	}
+
+	return stub.PutState(key, valueAsBytes)
+}
+
+// DelState for the entity of doctype with id and its secondary index entries
+func del_state(stub shim.ChaincodeStubInterface, doctype string, id string) error {
+	key, err := entity_key(stub, doctype, id)
+	if err != nil {
+		return err
+	}
+
+	oldAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return err
+	}
+	err = update_indexes(stub, doctype, id, oldAsBytes, nil)
+	if err != nil {
+		return err
+	}
+
+	return stub.DelState(key)
+}
+
+// ============================================================================================================================
+// Secondary indexes - composite keys "<doctype>~<field>" + [value, id], used for queries when there is no CouchDB
+// ============================================================================================================================
+var index_fields = map[string][]string{
+	"Asset":   {"npoid", "donorid", "status", "producttype"},
+	"Need":    {"npoid", "status", "producttype"},
+	"Loan":    {"npoid", "recipientid", "assetid", "status"},
+	"Receipt": {"donorid", "npoid"},
+}
+
+// String value of a top level JSON field of an entity, "" when missing
+func json_field(valueAsBytes []byte, field string) string {
+	if valueAsBytes == nil {
+		return ""
+	}
+	var fields map[string]interface{}
+	json.Unmarshal(valueAsBytes, &fields)
+	value, _ := fields[field].(string)
+	return value
+}
+
+// Drop the index entries of the old value and write the ones of the new value
+func update_indexes(stub shim.ChaincodeStubInterface, doctype string, id string, oldAsBytes []byte, newAsBytes []byte) error {
+	for _, field := range index_fields[doctype] {
+		oldValue := json_field(oldAsBytes, field)
+		newValue := json_field(newAsBytes, field)
+		if oldAsBytes != nil && newAsBytes != nil && oldValue == newValue {
+			continue
+		}
+
+		if oldAsBytes != nil && oldValue != "" {
+			indexKey, err := stub.CreateCompositeKey(doctype+"~"+field, []string{oldValue, id})
+			if err != nil {
+				return err
+			}
+			err = stub.DelState(indexKey)
+			if err != nil {
+				return err
+			}
+		}
+		if newAsBytes != nil && newValue != "" {
+			indexKey, err := stub.CreateCompositeKey(doctype+"~"+field, []string{newValue, id})
+			if err != nil {
+				return err
+			}
+			err = stub.PutState(indexKey, []byte{0x00})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Ids of the entities of doctype whose indexed field has value
+func index_ids(stub shim.ChaincodeStubInterface, doctype string, field string, value string) ([]string, error) {
+	ids := []string{}
+
+	indexIterator, err := stub.GetStateByPartialCompositeKey(doctype+"~"+field, []string{value})
+	if err != nil {
+		return nil, err
+	}
+	defer indexIterator.Close()
+
+	for indexIterator.HasNext() {
+		aKeyValue, err := indexIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(aKeyValue.Key)
+		if err != nil || len(keyParts) != 2 {
+			continue
+		}
+		ids = append(ids, keyParts[1])
+	}
+
+	return ids, nil
+}
+
+// ============================================================================================================================
+// rebuild_indexes - args: doctype, page size, optional bookmark. Write index entries for entities stored before
+// the indexes existed, call again with the returned bookmark until it is empty
+// ============================================================================================================================
+func (t *SimpleChaincode) rebuild_indexes(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 2 {
+		return shim.Error("Incorrect number of arguments. Expecting doctype, page size and optional bookmark")
+	}
+	doctype := args[0]
+	if _, ok := index_fields[doctype]; !ok {
+		return shim.Error("{\"Error\":\"" + doctype + " has no indexes\"}")
+	}
+
+	pageSize, bookmark, err := parse_page_args(args[1:])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination(doctype, []string{}, pageSize, bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		aKeyValue, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, keyParts, err := stub.SplitCompositeKey(aKeyValue.Key)
+		if err != nil || len(keyParts) != 1 {
+			continue
+		}
+		err = update_indexes(stub, doctype, keyParts[0], nil, aKeyValue.Value)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	err = emit_event(stub, ChaincodeEvent{Name: "indexes_rebuilt", Reason: doctype})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte(metadata.Bookmark))
+}
+
+// Decode a legacy flat-key value into its struct, returning the doctype and re-encoded value
+func decode_legacy_entity(valueAsBytes []byte) (string, string, []byte, error) {
+	var header struct {
+		ObjectType  string `json:"doctype"`
+		Id          string `json:"id"`
+		Total_count *int   `json:"totalcount"`
+	}
+	err := json.Unmarshal(valueAsBytes, &header)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	var entity interface{}
+	switch {
+	case header.ObjectType == "Asset":
+		var temp_asset Asset
+		json.Unmarshal(valueAsBytes, &temp_asset)
+		temp_asset.Owner_history = scrub_owner_history(temp_asset.Owner_history)
+		entity = temp_asset
+	case header.ObjectType == "Donor":
+		var temp_donor Donor
+		json.Unmarshal(valueAsBytes, &temp_donor)
+		entity = temp_donor
+	case header.ObjectType == "NPO":
+		var temp_npo NPO
+		json.Unmarshal(valueAsBytes, &temp_npo)
+		entity = temp_npo
+	case header.ObjectType == "Recipient":
+		var temp_rec Recipient
+		json.Unmarshal(valueAsBytes, &temp_rec)
+		entity = temp_rec
+	case header.ObjectType == "Need" || (header.ObjectType == "" && header.Total_count != nil):
+		// needs were stored without a doctype before composite keys
+		var temp_need Need
+		json.Unmarshal(valueAsBytes, &temp_need)
+		temp_need.ObjectType = "Need"
+		header.ObjectType = "Need"
+		entity = temp_need
+	default:
+		return "", "", nil, fmt.Errorf("unknown doctype '%s'", header.ObjectType)
+	}
+
+	entityAsBytes, _ := json.Marshal(entity)
+	return header.ObjectType, header.Id, entityAsBytes, nil
+}
+
+// Owner relations without PII. Relations written before recipient details went private carry the recipient's
+// name in Username and its type in User_type
+func scrub_owner_history(history []OwnerRelation) []OwnerRelation {
+	scrubbed := []OwnerRelation{}
+	for _, v := range history {
+		v.Username = ""
+		if v.User_type != "Donor" && v.User_type != "NPO" {
+			v.User_type = "Recipient"
+		}
+		scrubbed = append(scrubbed, v)
+	}
+	return scrubbed
+}
+
+// Move the PII of a legacy Donor or Recipient into the private collection and set the entity's PII_hash.
+// Each entity is salted with a hash of the caller's secret and its id. Returns the entity to store
+func migrate_legacy_pii(stub shim.ChaincodeStubInterface, doctype string, id string, legacyAsBytes []byte, entityAsBytes []byte, secret string) ([]byte, error) {
+	key, err := entity_key(stub, doctype, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// private details already written under the flat key
+	privateAsBytes, err := stub.GetPrivateData(PIICollection, id)
+	if err != nil {
+		return nil, err
+	}
+	if privateAsBytes != nil {
+		err = stub.PutPrivateData(PIICollection, key, privateAsBytes)
+		if err != nil {
+			return nil, err
+		}
+		err = stub.DelPrivateData(PIICollection, id)
+		if err != nil {
+			return nil, err
+		}
+		return entityAsBytes, nil
+	}
+
+	privateAsBytes, err = stub.GetPrivateData(PIICollection, key)
+	if err != nil {
+		return nil, err
+	}
+	if privateAsBytes != nil {
+		return entityAsBytes, nil
+	}
+
+	var legacy struct {
+		Phone string `json:"phone"`
+		Name  string `json:"name"`
+		Types string `json:"type"`
+	}
+	json.Unmarshal(legacyAsBytes, &legacy)
+	if legacy.Phone == "" && (doctype != "Recipient" || (legacy.Name == "" && legacy.Types == "")) {
+		return entityAsBytes, nil
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("{\"Error\":\"%s %s has details to move to the private collection, pass a salt in the transient map under migration\"}", doctype, id)
+	}
+	salt := hash_pii(secret, id)
+
+	if doctype == "Donor" {
+		var temp_donor Donor
+		json.Unmarshal(entityAsBytes, &temp_donor)
+		err = put_donor_private(stub, DonorPrivate{Id: id, Phone: legacy.Phone, Salt: salt})
+		if err != nil {
+			return nil, err
+		}
+		temp_donor.PII_hash = hash_pii(salt, legacy.Phone)
+		entityAsBytes, _ = json.Marshal(temp_donor)
+	} else {
+		var temp_rec Recipient
+		json.Unmarshal(entityAsBytes, &temp_rec)
+		err = put_recipient_private(stub, RecipientPrivate{Id: id, Name: legacy.Name, Types: legacy.Types, Salt: salt})
+		if err != nil {
+			return nil, err
+		}
+		temp_rec.PII_hash = hash_pii(salt, legacy.Name, legacy.Types)
+		entityAsBytes, _ = json.Marshal(temp_rec)
+	}
+
+	return entityAsBytes, nil
+}
+
+// ============================================================================================================================
+// migrate_keys - rewrite entities stored under flat keys to composite keys
+// args: optional maximum number of entities to migrate in this transaction. Call again until "remaining" is false.
+// Donor phones and recipient names and types move to the private collection, salted from the transient
+// "migration" {"salt"} secret. Owner histories lose their usernames
+// ============================================================================================================================
+func (t *SimpleChaincode) migrate_keys(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	type MigrationResult struct {
+		Migrated  int      `json:"migrated"`
+		Skipped   []string `json:"skipped"`
+		Remaining bool     `json:"remaining"`
+	}
+	var result MigrationResult
+	result.Skipped = []string{}
+
+	limit := 0
+	if len(args) > 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 0 or 1")
+	}
+	if len(args) == 1 {
+		var err error
+		limit, err = strconv.Atoi(args[0])
+		if err != nil || limit < 1 {
+			return shim.Error("{\"Error\":\"Limit must be a positive number\"}")
+		}
+	}
+
+	var migration struct {
+		Salt string `json:"salt"`
+	}
+	_, err := get_optional_transient_json(stub, "migration", &migration)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// an empty range only covers simple keys, composite keys are never returned
+	flatIterator, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer flatIterator.Close()
+
+	for flatIterator.HasNext() {
+		aKeyValue, err := flatIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if limit > 0 && result.Migrated == limit {
+			result.Remaining = true
+			break
+		}
+
+		doctype, id, entityAsBytes, err := decode_legacy_entity(aKeyValue.Value)
+		if err != nil || id != aKeyValue.Key {
+			result.Skipped = append(result.Skipped, aKeyValue.Key)
+			continue
+		}
+		if check_not_exists(stub, doctype, id) != nil {
+			result.Skipped = append(result.Skipped, aKeyValue.Key)
+			continue
+		}
+
+		if doctype == "Donor" || doctype == "Recipient" {
+			entityAsBytes, err = migrate_legacy_pii(stub, doctype, id, aKeyValue.Value, entityAsBytes, migration.Salt)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+
+		err = put_state(stub, doctype, id, entityAsBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = stub.DelState(aKeyValue.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		result.Migrated++
+	}
+
+	err = emit_event(stub, ChaincodeEvent{Name: "keys_migrated", Reason: strconv.Itoa(result.Migrated)})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
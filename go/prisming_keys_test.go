@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Write a value under a flat key, the way entities were stored before composite keys
+func (s *mock_stub) put_legacy(t *testing.T, key string, value string) {
+	t.Helper()
+	s.MockTransactionStart("legacy")
+	err := s.MockStub.PutState(key, []byte(value))
+	s.MockTransactionEnd("legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEntitiesLiveUnderCompositeKeys(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+
+	if s.State["d100"] != nil {
+		t.Fatal("donor stored under a flat key")
+	}
+	key, _ := s.CreateCompositeKey("Donor", []string{"d100"})
+	if s.State[key] == nil {
+		t.Fatal("donor not stored under its composite key")
+	}
+
+	// the same id in another doctype is another entity
+	expect_ok(t, s.invoke(operator_admin, "enroll_npo", "d100", "npo named like a donor"), "enroll_npo d100")
+	var temp_donor Donor
+	s.read(t, "Donor", "d100", &temp_donor)
+	if temp_donor.Name != "donor one" {
+		t.Fatalf("donor overwritten by the NPO - %+v", temp_donor)
+	}
+}
+
+func TestGetStateRefusesAnotherDoctype(t *testing.T) {
+	s := new_mock_stub(t)
+
+	key, _ := s.CreateCompositeKey("Donor", []string{"d100"})
+	s.put_legacy(t, key, `{"doctype":"NPO","id":"d100"}`)
+
+	_, err := get_state(s, "Donor", "d100")
+	if err == nil || !strings.Contains(err.Error(), "stored as NPO") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	_, err = get_state(s, "Donor", "")
+	if err == nil {
+		t.Fatal("get_state with an empty id succeeded")
+	}
+}
+
+func TestMigrateKeysMovesFlatEntities(t *testing.T) {
+	s := new_mock_stub(t)
+	s.put_legacy(t, "d9", `{"doctype":"Donor","id":"d9","name":"old donor","phone":"010-1111-2222","credit":3}`)
+	s.put_legacy(t, "e9", `{"id":"e9","npoid":"n1","name":"old need","producttype":"의류","totalcount":5}`)
+	s.put_legacy(t, "junk", `not json`)
+
+	expect_error(t, s.invoke(operator_admin, "migrate_keys"), "migrate_keys without a salt")
+	expect_error(t, s.invoke(npo_user, "migrate_keys"), "migrate_keys as an npo")
+
+	res := s.invoke_transient(operator_admin, map[string]interface{}{"migration": map[string]string{"salt": "secret"}}, "migrate_keys")
+	expect_ok(t, res, "migrate_keys")
+	var result struct {
+		Migrated  int      `json:"migrated"`
+		Skipped   []string `json:"skipped"`
+		Remaining bool     `json:"remaining"`
+	}
+	json.Unmarshal(res.Payload, &result)
+	if result.Migrated != 2 || result.Remaining || !contains(result.Skipped, "junk") {
+		t.Fatalf("unexpected result %s", res.Payload)
+	}
+
+	if s.State["d9"] != nil || s.State["e9"] != nil {
+		t.Fatal("flat keys left behind")
+	}
+	var temp_donor Donor
+	s.read(t, "Donor", "d9", &temp_donor)
+	if temp_donor.Credit != 3 || temp_donor.PII_hash != hash_pii(hash_pii("secret", "d9"), "010-1111-2222") {
+		t.Fatalf("unexpected donor %+v", temp_donor)
+	}
+	key, _ := s.CreateCompositeKey("Donor", []string{"d9"})
+	if strings.Contains(string(s.State[key]), "010-1111-2222") {
+		t.Fatal("phone left in the public state")
+	}
+	var temp_need Need
+	s.read(t, "Need", "e9", &temp_need)
+	if temp_need.ObjectType != "Need" || temp_need.Total_count != 5 {
+		t.Fatalf("unexpected need %+v", temp_need)
+	}
+}
+
+func TestMigrateKeysStopsAtTheLimit(t *testing.T) {
+	s := new_mock_stub(t)
+	s.put_legacy(t, "n8", `{"doctype":"NPO","id":"n8","name":"old npo one"}`)
+	s.put_legacy(t, "n9", `{"doctype":"NPO","id":"n9","name":"old npo two"}`)
+
+	res := s.invoke(operator_admin, "migrate_keys", "1")
+	expect_ok(t, res, "migrate_keys first batch")
+	if !strings.Contains(string(res.Payload), `"remaining":true`) {
+		t.Fatalf("unexpected result %s", res.Payload)
+	}
+	res = s.invoke(operator_admin, "migrate_keys", "1")
+	expect_ok(t, res, "migrate_keys second batch")
+	var temp_npo NPO
+	s.read(t, "NPO", "n8", &temp_npo)
+	s.read(t, "NPO", "n9", &temp_npo)
+}
+
+func TestIdsAreMintedFromTheTxId(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+
+	res := s.invoke(donor_user, "propose_asset", "", "coat", "d100", "n100", "의류", "hash")
+	expect_ok(t, res, "propose_asset without an id")
+	assetId := string(res.Payload)
+	var temp_asset Asset
+	s.read(t, "Asset", assetId, &temp_asset)
+
+	// every endorser of the same tx mints the same id, another doctype gets another one
+	s.MockTransactionStart(fmt.Sprintf("tx%04d", s.txCount))
+	if mint_id(s, "Asset") != assetId || mint_id(s, "Need") == assetId {
+		t.Fatalf("minted id %s is not derived from the tx ID and doctype", assetId)
+	}
+	s.MockTransactionEnd(fmt.Sprintf("tx%04d", s.txCount))
+	if !strings.HasPrefix(assetId, "a") || len(assetId) != 17 {
+		t.Fatalf("unexpected minted id %s", assetId)
+	}
+
+	res = s.invoke(npo_user, "enroll_needs", "", "n100", "coats", "의류", "5")
+	expect_ok(t, res, "enroll_needs without an id")
+	if !strings.HasPrefix(string(res.Payload), "e") || string(res.Payload) == assetId {
+		t.Fatalf("unexpected minted need id %s", res.Payload)
+	}
+
+	// a passed id is kept
+	res = s.invoke(donor_user, "propose_asset", "a200", "scarf", "d100", "n100", "의류", "hash")
+	expect_ok(t, res, "propose_asset with an id")
+	if string(res.Payload) != "a200" {
+		t.Fatalf("passed id replaced by %s", res.Payload)
+	}
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"strconv"
+	"time"
+)
+
+// Asset statuses
+const (
+	StatusProposed       = "Proposed"
+	StatusApproved       = "Approved"
+	StatusBorrowed       = "Borrowed"
+	StatusPendingReceipt = "PendingReceipt" // given by the NPO, not yet confirmed by the recipient
+	StatusGiven          = "Given"
+	StatusRejected       = "Rejected"
+	StatusWithdrawn      = "Withdrawn"
+	StatusRetired        = "Retired"
+	StatusDeleted        = "Deleted" // never stored, the asset is removed from state
+)
+
+// One allowed move of the asset state machine, From "" is the creation of the asset
+type Transition struct {
+	Action string `json:"action"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+}
+
+// Who moved an asset from one status to another, and when
+type StatusChange struct {
+	Action    string `json:"action"`
+	From      string `json:"from"`
+	To        string `json:"to"`
+	By        string `json:"by"` // fingerprint of the submitter
+	Timestamp string `json:"timestamp"`
+	TxId      string `json:"txId"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// ============================================================================================================================
+// Asset state machine - every status change goes through apply_transition
+// ============================================================================================================================
+var asset_transitions = []Transition{
+	{Action: "propose", From: "", To: StatusProposed},
+	{Action: "approve", From: StatusProposed, To: StatusApproved},
+	{Action: "reject", From: StatusProposed, To: StatusRejected},
+	{Action: "withdraw", From: StatusProposed, To: StatusWithdrawn},
+	{Action: "delete", From: StatusProposed, To: StatusDeleted},
+	{Action: "borrow", From: StatusApproved, To: StatusBorrowed},
+	{Action: "return", From: StatusBorrowed, To: StatusApproved},
+	{Action: "give", From: StatusApproved, To: StatusPendingReceipt},
+	{Action: "confirm_receipt", From: StatusPendingReceipt, To: StatusGiven},
+	{Action: "expire_receipt", From: StatusPendingReceipt, To: StatusApproved},
+	{Action: "retire", From: StatusApproved, To: StatusRetired},
+}
+
+// Days a recipient has to confirm a give before the NPO may take the asset back
+const ReceiptTimeoutDays = 7
+
+// Returned when an action is not allowed from the asset's current status
+type TransitionError struct {
+	AssetId string   `json:"assetId"`
+	Status  string   `json:"status"`
+	Action  string   `json:"action"`
+	Allowed []string `json:"allowed"`
+}
+
+func (e *TransitionError) Error() string {
+	type errorBody struct {
+		Error string `json:"Error"`
+		Type  string `json:"type"`
+		*TransitionError
+	}
+	body := errorBody{
+		Error:           fmt.Sprintf("Cannot %s asset %s in status %s", e.Action, e.AssetId, e.Status),
+		Type:            "TransitionError",
+		TransitionError: e,
+	}
+	bodyAsBytes, _ := json.Marshal(body)
+	return string(bodyAsBytes)
+}
+
+// Transitions leaving status
+func allowed_transitions(status string) []Transition {
+	allowed := []Transition{}
+	for _, v := range asset_transitions {
+		if v.From == status {
+			allowed = append(allowed, v)
+		}
+	}
+	return allowed
+}
+
+// Find the transition for action from the asset's status, or a *TransitionError
+func find_transition(temp_asset Asset, action string) (Transition, error) {
+	allowed := allowed_transitions(temp_asset.Status)
+	actions := []string{}
+	for _, v := range allowed {
+		if v.Action == action {
+			return v, nil
+		}
+		actions = append(actions, v.Action)
+	}
+	return Transition{}, &TransitionError{AssetId: temp_asset.Id, Status: temp_asset.Status, Action: action, Allowed: actions}
+}
+
+// Move the asset along the transition for action, recording the submitter and tx time
+func apply_transition(stub shim.ChaincodeStubInterface, temp_asset *Asset, action string, reason string) error {
+	transition, err := find_transition(*temp_asset, action)
+	if err != nil {
+		return err
+	}
+
+	fingerprint, err := get_caller_fingerprint(stub)
+	if err != nil {
+		return err
+	}
+	txTime, err := get_tx_time(stub)
+	if err != nil {
+		return err
+	}
+
+	temp_asset.Status_history = append(temp_asset.Status_history, StatusChange{
+		Action:    action,
+		From:      transition.From,
+		To:        transition.To,
+		By:        fingerprint,
+		Timestamp: txTime.Format(time.RFC3339),
+		TxId:      stub.GetTxID(),
+		Reason:    reason,
+	})
+	temp_asset.Status = transition.To
+
+	return nil
+}
+
+// Transaction timestamp from the proposal, identical on every endorser
+func get_tx_time(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("{\"Error\":\"Failed to get tx timestamp - %s\"}", err.Error())
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+// ============================================================================================================================
+// get_asset_transitions - the whole asset state machine, for client apps
+// ============================================================================================================================
+func (t *SimpleChaincode) get_asset_transitions(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	transitionsAsBytes, _ := json.Marshal(asset_transitions)
+	return shim.Success(transitionsAsBytes)
+}
+
+// ============================================================================================================================
+// get_allowed_actions - args: asset id. Transitions available from the asset's current status
+// ============================================================================================================================
+func (t *SimpleChaincode) get_allowed_actions(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var temp_asset Asset
+	temp_asset_by_byte, err := get_state(stub, "Asset", args[0])
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get asset state\"}"
+		return shim.Error(jsonResp)
+	}
+	if temp_asset_by_byte == nil {
+		jsonResp := "{\"Error\":\"Nil amount asset state\"}"
+		return shim.Error(jsonResp)
+	}
+	json.Unmarshal(temp_asset_by_byte, &temp_asset)
+
+	allowedAsBytes, _ := json.Marshal(allowed_transitions(temp_asset.Status))
+	return shim.Success(allowedAsBytes)
+}
+
+// ============================================================================================================================
+// retire_asset - args: asset id, npo id, reason. Take an approved asset out of circulation
+// ============================================================================================================================
+func (t *SimpleChaincode) retire_asset(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	var temp_asset Asset
+	temp_asset_by_byte, err := get_state(stub, "Asset", args[0])
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get asset state\"}"
+		return shim.Error(jsonResp)
+	}
+	if temp_asset_by_byte == nil {
+		jsonResp := "{\"Error\":\"Nil amount asset state\"}"
+		return shim.Error(jsonResp)
+	}
+	json.Unmarshal(temp_asset_by_byte, &temp_asset)
+
+	if temp_asset.NPOId != args[1] {
+		jsonResp := "{\"Error\":\"Asset is not owned by given NPO\"}"
+		return shim.Error(jsonResp)
+	}
+
+	var temp_npo NPO
+	temp_npo_by_byte, err := get_state(stub, "NPO", temp_asset.NPOId)
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get npo state \"}"
+		return shim.Error(jsonResp)
+	}
+	json.Unmarshal(temp_npo_by_byte, &temp_npo)
+
+	err = check_binding(stub, temp_npo.IdentityBinding, temp_npo.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	old_status := temp_asset.Status
+	err = apply_transition(stub, &temp_asset, "retire", args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	AssetAsBytes, _ := json.Marshal(temp_asset)
+
+	err = put_state(stub, "Asset", temp_asset.Id, AssetAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	event := asset_event("asset_retired", temp_asset, old_status)
+	event.Reason = args[2]
+	err = emit_event(stub, event)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// Store a proposed asset closed by reject or withdraw, drop it from the NPO's list and tell the donor app
+func close_proposed_asset(stub shim.ChaincodeStubInterface, temp_asset Asset, temp_npo NPO, eventName string, reason string) pb.Response {
+	AssetAsBytes, _ := json.Marshal(temp_asset)
+
+	err := put_state(stub, "Asset", temp_asset.Id, AssetAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the donor keeps the asset in its list as a record of what happened
+	temp_npo.Assets_array = remove_string(temp_npo.Assets_array, temp_asset.Id)
+	NpoAsBytes, _ := json.Marshal(temp_npo)
+
+	err = put_state(stub, "NPO", temp_npo.Id, NpoAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	event := asset_event(eventName, temp_asset, StatusProposed)
+	event.Reason = reason
+	err = emit_event(stub, event)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================================================================================
+// reject_asset - args: asset id, npo id, reason. The NPO turns down a proposal, the asset stays on the ledger
+// ============================================================================================================================
+func (t *SimpleChaincode) reject_asset(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	var temp_asset Asset
+	temp_asset_by_byte, err := get_state(stub, "Asset", args[0])
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get asset state\"}"
+		return shim.Error(jsonResp)
+	}
+	if temp_asset_by_byte == nil {
+		jsonResp := "{\"Error\":\"Nil amount asset state\"}"
+		return shim.Error(jsonResp)
+	}
+	json.Unmarshal(temp_asset_by_byte, &temp_asset)
+
+	if temp_asset.NPOId != args[1] {
+		jsonResp := "{\"Error\":\"Asset is not owned by given NPO\"}"
+		return shim.Error(jsonResp)
+	}
+
+	var temp_npo NPO
+	temp_npo_by_byte, err := get_state(stub, "NPO", temp_asset.NPOId)
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get npo state \"}"
+		return shim.Error(jsonResp)
+	}
+	json.Unmarshal(temp_npo_by_byte, &temp_npo)
+
+	err = check_binding(stub, temp_npo.IdentityBinding, temp_npo.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = apply_transition(stub, &temp_asset, "reject", args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return close_proposed_asset(stub, temp_asset, temp_npo, "asset_rejected", args[2])
+}
+
+// ============================================================================================================================
+// withdraw_asset - args: asset id, donor id. The donor takes back a proposal before the NPO decides
+// ============================================================================================================================
+func (t *SimpleChaincode) withdraw_asset(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	var temp_asset Asset
+	temp_asset_by_byte, err := get_state(stub, "Asset", args[0])
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get asset state\"}"
+		return shim.Error(jsonResp)
+	}
+	if temp_asset_by_byte == nil {
+		jsonResp := "{\"Error\":\"Nil amount asset state\"}"
+		return shim.Error(jsonResp)
+	}
+	json.Unmarshal(temp_asset_by_byte, &temp_asset)
+
+	if temp_asset.DonorId != args[1] {
+		jsonResp := "{\"Error\":\"Asset was not proposed by given donor\"}"
+		return shim.Error(jsonResp)
+	}
+
+	var temp_donor Donor
+	temp_donor_by_byte, err := get_state(stub, "Donor", temp_asset.DonorId)
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get donor state\"}"
+		return shim.Error(jsonResp)
+	}
+	json.Unmarshal(temp_donor_by_byte, &temp_donor)
+
+	err = check_binding(stub, temp_donor.IdentityBinding, temp_donor.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var temp_npo NPO
+	temp_npo_by_byte, err := get_state(stub, "NPO", temp_asset.NPOId)
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get npo state \"}"
+		return shim.Error(jsonResp)
+	}
+	json.Unmarshal(temp_npo_by_byte, &temp_npo)
+
+	err = apply_transition(stub, &temp_asset, "withdraw", "withdrawn by donor")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return close_proposed_asset(stub, temp_asset, temp_npo, "asset_withdrawn", "withdrawn by donor")
+}
+
+// ============================================================================================================================
+// confirm_receipt - args: asset id. The recipient, or a delegate such as a case worker, confirms a given asset arrived
+// ============================================================================================================================
+func (t *SimpleChaincode) confirm_receipt(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var temp_asset Asset
+	temp_asset_by_byte, err := get_state(stub, "Asset", args[0])
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get asset state\"}"
+		return shim.Error(jsonResp)
+	}
+	if temp_asset_by_byte == nil {
+		jsonResp := "{\"Error\":\"Nil amount asset state\"}"
+		return shim.Error(jsonResp)
+	}
+	json.Unmarshal(temp_asset_by_byte, &temp_asset)
+
+	// only a PendingReceipt asset has a recipient to confirm
+	_, err = find_transition(temp_asset, "confirm_receipt")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var temp_rec Recipient
+	temp_rec_by_byte, err := get_state(stub, "Recipient", temp_asset.RecipientId)
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get rec state\"}"
+		return shim.Error(jsonResp)
+	}
+	if temp_rec_by_byte == nil {
+		jsonResp := "{\"Error\":\"Asset " + temp_asset.Id + " is not being given to a recipient\"}"
+		return shim.Error(jsonResp)
+	}
+	json.Unmarshal(temp_rec_by_byte, &temp_rec)
+
+	err = check_binding(stub, temp_rec.IdentityBinding, temp_rec.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	old_status := temp_asset.Status
+	err = apply_transition(stub, &temp_asset, "confirm_receipt", "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	AssetAsBytes, _ := json.Marshal(temp_asset)
+
+	err = put_state(stub, "Asset", temp_asset.Id, AssetAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	event := asset_event("asset_receipt_confirmed", temp_asset, old_status)
+	event.RecipientId = temp_rec.Id
+	err = emit_event(stub, event)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================================================================================
+// expire_receipt - args: asset id, npo id. The NPO takes back a given asset the recipient did not confirm
+// within ReceiptTimeoutDays
+// ============================================================================================================================
+func (t *SimpleChaincode) expire_receipt(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	var temp_asset Asset
+	temp_asset_by_byte, err := get_state(stub, "Asset", args[0])
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get asset state\"}"
+		return shim.Error(jsonResp)
+	}
+	if temp_asset_by_byte == nil {
+		jsonResp := "{\"Error\":\"Nil amount asset state\"}"
+		return shim.Error(jsonResp)
+	}
+	json.Unmarshal(temp_asset_by_byte, &temp_asset)
+
+	if temp_asset.NPOId != args[1] {
+		jsonResp := "{\"Error\":\"Asset is not owned by given NPO\"}"
+		return shim.Error(jsonResp)
+	}
+
+	var temp_npo NPO
+	temp_npo_by_byte, err := get_state(stub, "NPO", temp_asset.NPOId)
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get npo state \"}"
+		return shim.Error(jsonResp)
+	}
+	json.Unmarshal(temp_npo_by_byte, &temp_npo)
+
+	err = check_binding(stub, temp_npo.IdentityBinding, temp_npo.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the give is the last status change of a PendingReceipt asset
+	txTime, err := get_tx_time(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if temp_asset.Status == StatusPendingReceipt && len(temp_asset.Status_history) > 0 {
+		given, err := time.Parse(time.RFC3339, temp_asset.Status_history[len(temp_asset.Status_history)-1].Timestamp)
+		if err == nil && txTime.Before(given.AddDate(0, 0, ReceiptTimeoutDays)) {
+			jsonResp := "{\"Error\":\"Recipient has until " + given.AddDate(0, 0, ReceiptTimeoutDays).Format(time.RFC3339) + " to confirm receipt\"}"
+			return shim.Error(jsonResp)
+		}
+	}
+
+	old_status := temp_asset.Status
+	reason := "receipt not confirmed within " + strconv.Itoa(ReceiptTimeoutDays) + " days"
+	err = apply_transition(stub, &temp_asset, "expire_receipt", reason)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	recipientId := temp_asset.RecipientId
+	temp_asset.RecipientId = ""
+
+	var temp_owner_relation OwnerRelation
+	temp_owner_relation.Id = temp_npo.Id
+	temp_owner_relation.User_type = temp_npo.ObjectType // back with the NPO
+	temp_asset.Owner_history = append(temp_asset.Owner_history, temp_owner_relation)
+
+	AssetAsBytes, _ := json.Marshal(temp_asset)
+
+	err = put_state(stub, "Asset", temp_asset.Id, AssetAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var temp_rec Recipient
+	temp_rec_by_byte, err := get_state(stub, "Recipient", recipientId)
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get rec state\"}"
+		return shim.Error(jsonResp)
+	}
+	if temp_rec_by_byte != nil {
+		json.Unmarshal(temp_rec_by_byte, &temp_rec)
+		temp_rec.Asset_array = remove_string(temp_rec.Asset_array, temp_asset.Id)
+
+		RecAsBytes, _ := json.Marshal(temp_rec)
+
+		err = put_state(stub, "Recipient", temp_rec.Id, RecAsBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	event := asset_event("asset_receipt_expired", temp_asset, old_status)
+	event.RecipientId = recipientId
+	event.Reason = reason
+	err = emit_event(stub, event)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// a100 approved by n100, r100 enrolled by its own identity
+func new_approved_stub(t *testing.T) *mock_stub {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+	expect_ok(t, s.invoke_transient(recipient_user, map[string]interface{}{"recipient": RecipientPrivate{Name: "홍길동", Types: "Temporary", Salt: "s1"}},
+		"enroll_recipient", "r100"), "enroll_recipient")
+	expect_ok(t, s.invoke(donor_user, "propose_asset", "a100", "coat", "d100", "n100", "의류", "hash"), "propose_asset")
+	expect_ok(t, s.invoke(npo_user, "approve_asset", "a100", "n100"), "approve_asset")
+	return s
+}
+
+func expect_status(t *testing.T, s *mock_stub, assetId string, status string) Asset {
+	t.Helper()
+	var temp_asset Asset
+	s.read(t, "Asset", assetId, &temp_asset)
+	if temp_asset.Status != status {
+		t.Fatalf("asset %s is %s, expected %s", assetId, temp_asset.Status, status)
+	}
+	return temp_asset
+}
+
+// The error of a refused transition, decoded
+func expect_transition_error(t *testing.T, s *mock_stub, caller test_identity, action string, function string, args ...string) TransitionError {
+	t.Helper()
+	res := s.invoke(caller, function, args...)
+	expect_error(t, res, function)
+	var body struct {
+		Error string `json:"Error"`
+		Type  string `json:"type"`
+		TransitionError
+	}
+	err := json.Unmarshal([]byte(res.Message), &body)
+	if err != nil || body.Type != "TransitionError" || body.Action != action {
+		t.Fatalf("%s - expected a TransitionError for %s, got %s", function, action, res.Message)
+	}
+	return body.TransitionError
+}
+
+func TestLifecycleRecordsEveryTransition(t *testing.T) {
+	s := new_approved_stub(t)
+
+	expect_ok(t, s.invoke(recipient_user, "borrow_asset", "a100", "r100"), "borrow_asset")
+	expect_status(t, s, "a100", StatusBorrowed)
+	expect_ok(t, s.invoke(npo_user, "get_back_asset", "a100", "r100", "worn"), "get_back_asset")
+	expect_status(t, s, "a100", StatusApproved)
+	expect_ok(t, s.invoke(npo_user, "give_asset", "a100", "r100"), "give_asset")
+	expect_status(t, s, "a100", StatusPendingReceipt)
+	expect_ok(t, s.invoke(recipient_user, "confirm_receipt", "a100"), "confirm_receipt")
+	temp_asset := expect_status(t, s, "a100", StatusGiven)
+
+	actions := []string{}
+	for _, v := range temp_asset.Status_history {
+		actions = append(actions, v.Action)
+	}
+	expect_ids(t, actions, "propose", "approve", "borrow", "return", "give", "confirm_receipt")
+
+	returned := temp_asset.Status_history[3]
+	if returned.From != StatusBorrowed || returned.To != StatusApproved || returned.Reason != "worn" || returned.By != npo_user.fingerprint() {
+		t.Fatalf("unexpected return %+v", returned)
+	}
+	if temp_asset.Status_history[2].By != recipient_user.fingerprint() || temp_asset.Status_history[0].Timestamp == "" {
+		t.Fatalf("unexpected borrow %+v", temp_asset.Status_history[2])
+	}
+
+	// a given asset is final
+	res := s.invoke(npo_user, "get_allowed_actions", "a100")
+	expect_ok(t, res, "get_allowed_actions")
+	if string(res.Payload) != "[]" {
+		t.Fatalf("given asset allows %s", res.Payload)
+	}
+}
+
+func TestTransitionsAreRefusedFromWrongStates(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+	expect_ok(t, s.invoke_transient(recipient_user, map[string]interface{}{"recipient": RecipientPrivate{Name: "홍길동", Types: "Temporary", Salt: "s1"}},
+		"enroll_recipient", "r100"), "enroll_recipient")
+	expect_ok(t, s.invoke(donor_user, "propose_asset", "a100", "coat", "d100", "n100", "의류", "hash"), "propose_asset")
+
+	// Proposed
+	refused := expect_transition_error(t, s, recipient_user, "borrow", "borrow_asset", "a100", "r100")
+	if refused.Status != StatusProposed || strings.Join(refused.Allowed, ",") != "approve,reject,withdraw,delete" {
+		t.Fatalf("unexpected error %+v", refused)
+	}
+	expect_transition_error(t, s, npo_user, "give", "give_asset", "a100", "r100")
+	expect_transition_error(t, s, npo_user, "retire", "retire_asset", "a100", "n100", "broken")
+	expect_status(t, s, "a100", StatusProposed)
+
+	// Approved
+	expect_ok(t, s.invoke(npo_user, "approve_asset", "a100", "n100"), "approve_asset")
+	expect_transition_error(t, s, npo_user, "approve", "approve_asset", "a100", "n100")
+	expect_transition_error(t, s, npo_user, "delete", "delete_asset", "a100", "n100")
+	expect_transition_error(t, s, donor_user, "withdraw", "withdraw_asset", "a100", "d100")
+	expect_transition_error(t, s, npo_user, "return", "get_back_asset", "a100", "r100")
+
+	// Borrowed
+	expect_ok(t, s.invoke(recipient_user, "borrow_asset", "a100", "r100"), "borrow_asset")
+	expect_transition_error(t, s, npo_user, "give", "give_asset", "a100", "r100")
+	expect_transition_error(t, s, npo_user, "retire", "retire_asset", "a100", "n100", "broken")
+
+	// Retired
+	expect_ok(t, s.invoke(npo_user, "get_back_asset", "a100", "r100"), "get_back_asset")
+	expect_ok(t, s.invoke(npo_user, "retire_asset", "a100", "n100", "broken"), "retire_asset")
+	expect_transition_error(t, s, recipient_user, "borrow", "borrow_asset", "a100", "r100")
+	temp_asset := expect_status(t, s, "a100", StatusRetired)
+	if temp_asset.Status_history[len(temp_asset.Status_history)-1].Reason != "broken" {
+		t.Fatalf("retire reason not recorded - %+v", temp_asset.Status_history)
+	}
+}
+
+func TestStateMachineIsPublished(t *testing.T) {
+	s := new_approved_stub(t)
+
+	res := s.invoke(donor_user, "get_asset_transitions")
+	expect_ok(t, res, "get_asset_transitions")
+	var transitions []Transition
+	json.Unmarshal(res.Payload, &transitions)
+	if len(transitions) != len(asset_transitions) {
+		t.Fatalf("unexpected transitions %s", res.Payload)
+	}
+
+	res = s.invoke(donor_user, "get_allowed_actions", "a100")
+	expect_ok(t, res, "get_allowed_actions")
+	var allowed []Transition
+	json.Unmarshal(res.Payload, &allowed)
+	actions := []string{}
+	for _, v := range allowed {
+		actions = append(actions, v.Action)
+	}
+	expect_ids(t, actions, "borrow", "give", "retire")
+}
+
+func TestRejectAndWithdrawCloseProposals(t *testing.T) {
+	s := new_query_stub(t)
+
+	expect_error(t, s.invoke(donor_user, "reject_asset", "a101", "n100", "no boots"), "reject_asset as the donor")
+	expect_error(t, s.invoke(npo_user, "reject_asset", "a101", "n1", "no boots"), "reject_asset for another NPO")
+	expect_ok(t, s.invoke(npo_user, "reject_asset", "a101", "n100", "no boots"), "reject_asset")
+	rejected := expect_status(t, s, "a101", StatusRejected)
+	last := rejected.Status_history[len(rejected.Status_history)-1]
+	if last.Action != "reject" || last.Reason != "no boots" || last.By != npo_user.fingerprint() {
+		t.Fatalf("unexpected rejection %+v", last)
+	}
+
+	expect_error(t, s.invoke(other_donor, "withdraw_asset", "a102", "d100"), "withdraw_asset by another donor")
+	expect_ok(t, s.invoke(donor_user, "withdraw_asset", "a102", "d100"), "withdraw_asset")
+	expect_status(t, s, "a102", StatusWithdrawn)
+
+	// both leave the NPO's list and stay in the donor's as a record
+	var temp_npo NPO
+	s.read(t, "NPO", "n100", &temp_npo)
+	expect_ids(t, temp_npo.Assets_array, "a100")
+	var temp_donor Donor
+	s.read(t, "Donor", "d100", &temp_donor)
+	expect_ids(t, temp_donor.Assets_array, "a100", "a101", "a102")
+
+	// closed proposals are final, an approved asset can no longer be withdrawn
+	expect_transition_error(t, s, npo_user, "approve", "approve_asset", "a101", "n100")
+	expect_transition_error(t, s, donor_user, "withdraw", "withdraw_asset", "a102", "d100")
+	expect_transition_error(t, s, donor_user, "withdraw", "withdraw_asset", "a100", "d100")
+	expect_transition_error(t, s, npo_user, "reject", "reject_asset", "a100", "n100", "too late")
+}
+
+func TestRecipientsConfirmReceipt(t *testing.T) {
+	s := new_approved_stub(t)
+
+	expect_transition_error(t, s, recipient_user, "confirm_receipt", "confirm_receipt", "a100")
+	expect_ok(t, s.invoke(npo_user, "give_asset", "a100", "r100"), "give_asset")
+	if temp_asset := expect_status(t, s, "a100", StatusPendingReceipt); temp_asset.RecipientId != "r100" {
+		t.Fatalf("asset not given to r100 - %+v", temp_asset)
+	}
+	expect_error(t, s.invoke(other_recip, "confirm_receipt", "a100"), "confirm_receipt by another recipient")
+	expect_error(t, s.invoke(npo_user, "confirm_receipt", "a100"), "confirm_receipt by the NPO")
+
+	// a case worker the recipient delegated to confirms for it
+	expect_ok(t, s.invoke(recipient_user, "add_delegate", "Recipient", "r100", npo_user.fingerprint()), "add_delegate")
+	expect_ok(t, s.invoke(npo_user, "confirm_receipt", "a100"), "confirm_receipt by a delegate")
+	expect_status(t, s, "a100", StatusGiven)
+	expect_transition_error(t, s, npo_user, "expire_receipt", "expire_receipt", "a100", "n100")
+
+	// the donor sees both steps of the handoff
+	res := s.invoke(donor_user, "get_history", "a100")
+	expect_ok(t, res, "get_history")
+	var history []struct {
+		Value Asset `json:"value"`
+	}
+	json.Unmarshal(res.Payload, &history)
+	statuses := []string{}
+	for _, v := range history {
+		statuses = append(statuses, v.Value.Status)
+	}
+	expect_ids(t, statuses, StatusProposed, StatusApproved, StatusPendingReceipt, StatusGiven)
+}
+
+func TestUnconfirmedReceiptsExpire(t *testing.T) {
+	s := new_approved_stub(t)
+	expect_ok(t, s.invoke(npo_user, "give_asset", "a100", "r100"), "give_asset")
+
+	res := s.invoke(npo_user, "expire_receipt", "a100", "n100")
+	expect_error(t, res, "expire_receipt within the timeout")
+	if !strings.Contains(res.Message, "to confirm receipt") {
+		t.Fatalf("unexpected error %s", res.Message)
+	}
+
+	s.txCount += ReceiptTimeoutDays * 24 * 60
+	expect_error(t, s.invoke(npo_user, "expire_receipt", "a100", "n1"), "expire_receipt for another NPO")
+	expect_ok(t, s.invoke(npo_user, "expire_receipt", "a100", "n100"), "expire_receipt")
+
+	temp_asset := expect_status(t, s, "a100", StatusApproved)
+	last := temp_asset.Status_history[len(temp_asset.Status_history)-1]
+	back := temp_asset.Owner_history[len(temp_asset.Owner_history)-1]
+	if temp_asset.RecipientId != "" || last.Action != "expire_receipt" || last.Reason != "receipt not confirmed within 7 days" || back.Id != "n100" {
+		t.Fatalf("unexpected expired asset %+v", temp_asset)
+	}
+	var temp_rec Recipient
+	s.read(t, "Recipient", "r100", &temp_rec)
+	if contains(temp_rec.Asset_array, "a100") {
+		t.Fatal("expired asset left with the recipient")
+	}
+	expect_transition_error(t, s, recipient_user, "confirm_receipt", "confirm_receipt", "a100")
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"strconv"
+	"time"
+)
+
+// Loan statuses
+const (
+	LoanActive   = "Active"
+	LoanReturned = "Returned"
+)
+
+// Loan periods in days, a borrower may ask for up to MaxLoanDays and the NPO may renew MaxRenewals times
+const (
+	DefaultLoanDays = 14
+	MaxLoanDays     = 90
+	MaxRenewals     = 2
+)
+
+// One borrowing of an asset by a recipient, from borrow_asset until get_back_asset
+type Loan struct {
+	ObjectType       string        `json:"doctype"` // field for couchdb
+	Id               string        `json:"id"`
+	AssetId          string        `json:"assetid"`
+	RecipientId      string        `json:"recipientid"`
+	NPOId            string        `json:"npoid"`
+	Status           string        `json:"status"`
+	Borrowed         string        `json:"borrowed"` // tx time of borrow_asset, RFC3339
+	Due              string        `json:"due"`      // RFC3339
+	Renewals         []LoanRenewal `json:"renewals"`
+	Returned         string        `json:"returned"` // tx time of get_back_asset, RFC3339
+	Return_condition string        `json:"returncondition"`
+}
+
+type LoanRenewal struct {
+	Previous_due string `json:"previousdue"`
+	Due          string `json:"due"`
+	By           string `json:"by"` // fingerprint of the renewing identity
+	Timestamp    string `json:"timestamp"`
+	TxId         string `json:"txId"`
+}
+
+// Loan period from an optional chaincode arg, DefaultLoanDays when empty
+func parse_loan_days(daysAsString string) (int, error) {
+	if daysAsString == "" {
+		return DefaultLoanDays, nil
+	}
+	days, err := strconv.Atoi(daysAsString)
+	if err != nil || days < 1 || days > MaxLoanDays {
+		return 0, fmt.Errorf("{\"Error\":\"Loan days must be between 1 and %d\"}", MaxLoanDays)
+	}
+	return days, nil
+}
+
+// Is the active loan past its due date at txTime
+func loan_overdue(temp_loan Loan, txTime time.Time) bool {
+	if temp_loan.Status != LoanActive {
+		return false
+	}
+	due, err := time.Parse(time.RFC3339, temp_loan.Due)
+	return err == nil && txTime.After(due)
+}
+
+// Open a loan of the asset to the recipient, due days after the tx time
+func open_loan(stub shim.ChaincodeStubInterface, temp_asset Asset, recipientId string, days int) (Loan, error) {
+	var temp_loan Loan
+
+	txTime, err := get_tx_time(stub)
+	if err != nil {
+		return temp_loan, err
+	}
+
+	temp_loan.ObjectType = "Loan"
+	temp_loan.Id = mint_id(stub, "Loan")
+	temp_loan.AssetId = temp_asset.Id
+	temp_loan.RecipientId = recipientId
+	temp_loan.NPOId = temp_asset.NPOId
+	temp_loan.Status = LoanActive
+	temp_loan.Borrowed = txTime.Format(time.RFC3339)
+	temp_loan.Due = txTime.AddDate(0, 0, days).Format(time.RFC3339)
+	temp_loan.Renewals = []LoanRenewal{}
+
+	err = put_loan(stub, temp_loan)
+	return temp_loan, err
+}
+
+func get_loan(stub shim.ChaincodeStubInterface, loanId string) (Loan, error) {
+	var temp_loan Loan
+
+	temp_loan_by_byte, err := get_state(stub, "Loan", loanId)
+	if err != nil {
+		return temp_loan, fmt.Errorf("{\"Error\":\"Failed to get loan state\"}")
+	}
+	if temp_loan_by_byte == nil {
+		return temp_loan, fmt.Errorf("{\"Error\":\"Loan %s does not exist\"}", loanId)
+	}
+	json.Unmarshal(temp_loan_by_byte, &temp_loan)
+
+	return temp_loan, nil
+}
+
+func put_loan(stub shim.ChaincodeStubInterface, temp_loan Loan) error {
+	LoanAsBytes, _ := json.Marshal(temp_loan)
+
+	return put_state(stub, "Loan", temp_loan.Id, LoanAsBytes) //store loan by its Id
+}
+
+// Close the loan with the return time and condition
+func close_loan(stub shim.ChaincodeStubInterface, temp_loan Loan, condition string) error {
+	txTime, err := get_tx_time(stub)
+	if err != nil {
+		return err
+	}
+
+	temp_loan.Status = LoanReturned
+	temp_loan.Returned = txTime.Format(time.RFC3339)
+	temp_loan.Return_condition = condition
+
+	return put_loan(stub, temp_loan)
+}
+
+// ============================================================================================================================
+// renew_loan - args: loan id [, days]. Push the due date of an active loan back by days (default DefaultLoanDays)
+// ============================================================================================================================
+func (t *SimpleChaincode) renew_loan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2")
+	}
+
+	temp_loan, err := get_loan(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if temp_loan.Status != LoanActive {
+		return shim.Error("{\"Error\":\"Loan " + temp_loan.Id + " is " + temp_loan.Status + "\"}")
+	}
+	if len(temp_loan.Renewals) >= MaxRenewals {
+		return shim.Error("{\"Error\":\"Loan " + temp_loan.Id + " was already renewed " + strconv.Itoa(MaxRenewals) + " times\"}")
+	}
+
+	daysAsString := ""
+	if len(args) == 2 {
+		daysAsString = args[1]
+	}
+	days, err := parse_loan_days(daysAsString)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var temp_npo NPO
+	temp_npo_by_byte, err := get_state(stub, "NPO", temp_loan.NPOId)
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get npo state \"}"
+		return shim.Error(jsonResp)
+	}
+	json.Unmarshal(temp_npo_by_byte, &temp_npo)
+
+	err = check_binding(stub, temp_npo.IdentityBinding, temp_npo.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	fingerprint, err := get_caller_fingerprint(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	txTime, err := get_tx_time(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	due, err := time.Parse(time.RFC3339, temp_loan.Due)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var renewal LoanRenewal
+	renewal.Previous_due = temp_loan.Due
+	renewal.Due = due.AddDate(0, 0, days).Format(time.RFC3339)
+	renewal.By = fingerprint
+	renewal.Timestamp = txTime.Format(time.RFC3339)
+	renewal.TxId = stub.GetTxID()
+
+	temp_loan.Renewals = append(temp_loan.Renewals, renewal)
+	temp_loan.Due = renewal.Due
+
+	err = put_loan(stub, temp_loan)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emit_event(stub, ChaincodeEvent{
+		Name:        "loan_renewed",
+		AssetId:     temp_loan.AssetId,
+		NPOId:       temp_loan.NPOId,
+		RecipientId: temp_loan.RecipientId,
+		OldStatus:   temp_loan.Status,
+		NewStatus:   temp_loan.Status,
+		Reason:      "due " + temp_loan.Due,
+	})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================================================================================
+// get_overdue_loans - args: "npo" or "recipient", its id. Active loans past their due date at the tx time
+// ============================================================================================================================
+func (t *SimpleChaincode) get_overdue_loans(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	var indexName string
+	if args[0] == "npo" {
+		indexName = "Loan~npoid"
+	} else if args[0] == "recipient" {
+		indexName = "Loan~recipientid"
+		err := check_recipient_reader(stub, args[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	} else {
+		return shim.Error("{\"Error\":\"Overdue loans are listed by npo or recipient\"}")
+	}
+
+	txTime, err := get_tx_time(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	loansIterator, err := stub.GetStateByPartialCompositeKey(indexName, []string{args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer loansIterator.Close()
+
+	view := new_recipient_view(stub)
+	overdue := []Loan{}
+	for loansIterator.HasNext() {
+		aKeyValue, err := loansIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, keyParts, err := stub.SplitCompositeKey(aKeyValue.Key)
+		if err != nil || len(keyParts) != 2 {
+			continue
+		}
+
+		temp_loan, err := get_loan(stub, keyParts[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if loan_overdue(temp_loan, txTime) {
+			view.redact_entity(&temp_loan)
+			overdue = append(overdue, temp_loan)
+		}
+	}
+
+	overdueAsBytes, _ := json.Marshal(overdue)
+	return shim.Success(overdueAsBytes)
+}
+
+// ============================================================================================================================
+// list_loans - args: page size, optional bookmark
+// ============================================================================================================================
+func (t *SimpleChaincode) list_loans(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return list_entities(stub, "Loan", args)
+}
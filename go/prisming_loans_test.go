@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func (s *mock_stub) loan_of(t *testing.T, assetId string) Loan {
+	t.Helper()
+	var temp_asset Asset
+	s.read(t, "Asset", assetId, &temp_asset)
+	var temp_loan Loan
+	s.read(t, "Loan", temp_asset.Loan_id, &temp_loan)
+	return temp_loan
+}
+
+func overdue_ids(t *testing.T, s *mock_stub, caller test_identity, args ...string) []string {
+	t.Helper()
+	res := s.invoke(caller, "get_overdue_loans", args...)
+	expect_ok(t, res, "get_overdue_loans")
+	var loans []Loan
+	json.Unmarshal(res.Payload, &loans)
+	ids := []string{}
+	for _, v := range loans {
+		ids = append(ids, v.AssetId)
+	}
+	return ids
+}
+
+func TestLoansAreDueAndRenewed(t *testing.T) {
+	s := new_approved_stub(t)
+
+	expect_error(t, s.invoke(recipient_user, "borrow_asset", "a100", "r100", "91"), "borrow_asset past the longest loan")
+	expect_ok(t, s.invoke(recipient_user, "borrow_asset", "a100", "r100", "7"), "borrow_asset")
+	borrowed := mock_start.Add(time.Duration(s.txCount) * time.Minute)
+	temp_loan := s.loan_of(t, "a100")
+	if temp_loan.Status != LoanActive || temp_loan.RecipientId != "r100" || temp_loan.NPOId != "n100" ||
+		temp_loan.Borrowed != borrowed.Format(time.RFC3339) || temp_loan.Due != borrowed.AddDate(0, 0, 7).Format(time.RFC3339) {
+		t.Fatalf("unexpected loan %+v", temp_loan)
+	}
+
+	expect_error(t, s.invoke(recipient_user, "renew_loan", temp_loan.Id), "renew_loan by the borrower")
+	expect_error(t, s.invoke(npo_user, "renew_loan", temp_loan.Id, "0"), "renew_loan by no days")
+	expect_ok(t, s.invoke(npo_user, "renew_loan", temp_loan.Id, "3"), "first renew_loan")
+	expect_ok(t, s.invoke(npo_user, "renew_loan", temp_loan.Id, "3"), "second renew_loan")
+	res := s.invoke(npo_user, "renew_loan", temp_loan.Id, "3")
+	expect_error(t, res, "renew_loan past the renewal limit")
+	if !strings.Contains(res.Message, "already renewed 2 times") {
+		t.Fatalf("unexpected error %s", res.Message)
+	}
+
+	temp_loan = s.loan_of(t, "a100")
+	if len(temp_loan.Renewals) != MaxRenewals || temp_loan.Due != borrowed.AddDate(0, 0, 13).Format(time.RFC3339) {
+		t.Fatalf("unexpected renewed loan %+v", temp_loan)
+	}
+	renewal := temp_loan.Renewals[1]
+	if renewal.Previous_due != borrowed.AddDate(0, 0, 10).Format(time.RFC3339) || renewal.Due != temp_loan.Due || renewal.By != npo_user.fingerprint() {
+		t.Fatalf("unexpected renewal %+v", renewal)
+	}
+}
+
+func TestOverdueLoansAndReturns(t *testing.T) {
+	s := new_approved_stub(t)
+	expect_ok(t, s.invoke_transient(other_recip, map[string]interface{}{"recipient": RecipientPrivate{Name: "김철수", Types: "Temporary", Salt: "s2"}},
+		"enroll_recipient", "r101"), "enroll_recipient r101")
+	expect_ok(t, s.invoke(recipient_user, "borrow_asset", "a100", "r100"), "borrow_asset")
+	loanId := s.loan_of(t, "a100").Id
+
+	expect_ids(t, overdue_ids(t, s, npo_user, "npo", "n100"))
+
+	// a day past the default loan period
+	s.txCount += (DefaultLoanDays + 1) * 24 * 60
+	expect_ids(t, overdue_ids(t, s, npo_user, "npo", "n100"), "a100")
+	expect_ids(t, overdue_ids(t, s, recipient_user, "recipient", "r100"), "a100")
+	expect_ids(t, overdue_ids(t, s, npo_user, "npo", "n1"))
+	expect_error(t, s.invoke(other_recip, "get_overdue_loans", "recipient", "r100"), "get_overdue_loans of another recipient")
+	expect_error(t, s.invoke(npo_user, "get_overdue_loans", "donor", "d100"), "get_overdue_loans by donor")
+
+	// only the borrower can give it back
+	res := s.invoke(npo_user, "get_back_asset", "a100", "r101", "fine")
+	expect_error(t, res, "get_back_asset from another recipient")
+	if !strings.Contains(res.Message, "not borrowed by recipient r101") {
+		t.Fatalf("unexpected error %s", res.Message)
+	}
+	expect_ok(t, s.invoke(npo_user, "get_back_asset", "a100", "r100", "torn sleeve"), "get_back_asset")
+
+	var temp_loan Loan
+	s.read(t, "Loan", loanId, &temp_loan)
+	if temp_loan.Status != LoanReturned || temp_loan.Return_condition != "torn sleeve" ||
+		temp_loan.Returned != mock_start.Add(time.Duration(s.txCount)*time.Minute).Format(time.RFC3339) {
+		t.Fatalf("unexpected returned loan %+v", temp_loan)
+	}
+	temp_asset := expect_status(t, s, "a100", StatusApproved)
+	back := temp_asset.Owner_history[len(temp_asset.Owner_history)-1]
+	if temp_asset.Loan_id != "" || back.Id != "n100" || back.User_type != "NPO" {
+		t.Fatalf("return not recorded in the owner history - %+v", temp_asset)
+	}
+	expect_ids(t, overdue_ids(t, s, npo_user, "npo", "n100"))
+	expect_error(t, s.invoke(npo_user, "renew_loan", loanId), "renew_loan of a returned loan")
+
+	res = s.invoke(npo_user, "list_loans", "10")
+	expect_ok(t, res, "list_loans")
+	ids, _ := page_ids(t, res.Payload)
+	expect_ids(t, ids, loanId)
+}
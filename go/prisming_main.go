@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"encoding/json"
 	"strconv"
+	"time"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 )
@@ -16,9 +17,11 @@ type Donor struct {
 	ObjectType     string      `json:"doctype"` // field for couchdb
 	Id     string     `json:"id"`
 	Name     string     `json:"name"`
-	Phone     string	`json:"phone"`
+	PII_hash     string	`json:"piihash"` // salted hash of the DonorPrivate details
 	Credit     int     `json:"credit"`
+	Credit_sequence int `json:"creditsequence"` // Sequence of the donor's latest credit entry
 	Assets_array []string `json:"assetArray"`
+	IdentityBinding
 }
 
 
@@ -27,11 +30,21 @@ type Asset struct {
 	Id     string     `json:"id"`
 	Name  string `json:"name"`
 	DonorId     string     `json:"donorid"`
-	NPOId string `json:"npoid`
+	NPOId string `json:"npoid"`
 	Owner_history []OwnerRelation `json:"owner"`
 	Status     string     `json:"status"`
 	ProductType     string     `json:"producttype"`
 	Picture     string     `json:"pichash"` // generated by hashing algorithm
+	Status_history []StatusChange `json:"statushistory"`
+	Created     string     `json:"created"` // tx time of the proposal, RFC3339
+	Tags []string `json:"tags"` // optional sub-category, matched against need tags
+	NeedId string `json:"needid"` // need the asset was credited to on approval
+	Quantity int `json:"quantity"`
+	Unit string `json:"unit"` // unit of measure of Quantity, e.g. "ea", "box", "kg"
+	Parent_id string `json:"parentid"` // asset this one was split from on approval
+	Loan_id string `json:"loanid"` // active loan while Borrowed
+	RecipientId string `json:"recipientid"` // recipient the asset was given to, confirmed once Given
+	Declared_value int `json:"declaredvalue"` // donor's declared value of the whole quantity in KRW, for receipts
 
 }
 
@@ -41,14 +54,18 @@ type NPO struct {
 	Name     string     `json:"name"`
 	Assets_array []string `json:"assetsarray"`
 	Needs []string `json:"needs"`
+	Match_rule string `json:"matchrule"` // MatchOldest (default) or MatchUrgency
+	Eligibility map[string]EligibilityPolicy `json:"eligibility"` // by recipient type, see set_eligibility_policy
+	Receipt_sequences map[string]int `json:"receiptsequences"` // last receipt number issued, by year
+	IdentityBinding
 }
 
 type Recipient struct {
 	ObjectType     string      `json:"doctype"` // field for couchdb
 	Id     string	`json:"id"`
-	Name     string     `json:"name"`
-	Types string `json:"type"`
+	PII_hash string `json:"piihash"` // salted hash of the RecipientPrivate details
 	Asset_array []string `json:"assetarray"`
+	IdentityBinding
 }
 
 
@@ -61,13 +78,20 @@ type OwnerRelation struct {
 
 // Donation needs from NPO
 type Need struct {
+	ObjectType     string      `json:"doctype"` // field for couchdb
 	Id string `json:"id"`
-	NPOID string `json:"npoid`
+	NPOID string `json:"npoid"`
 	ProductType string `json:"producttype"`
 	Name string `json:"name"`
 	Status string `json:"status"`
 	Total_count int `json:"totalcount"`
 	Current_count int `json:"currentcount"`
+	Created string `json:"created"` // tx time of the enrollment, RFC3339
+	Urgency int `json:"urgency"` // higher is more urgent
+	Tags []string `json:"tags"`
+	Deadline string `json:"deadline"` // optional, RFC3339
+	Assets []string `json:"assets"` // assets credited to the need
+	Status_history []StatusChange `json:"statushistory"`
 }
 
 // ============================================================================================================================
@@ -85,6 +109,7 @@ func main() {
 // Activate when instantiating
 func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	fmt.Println("Marbles Is Starting Up")
+	stub = new_invocation_stub(stub)
 	funcName, args := stub.GetFunctionAndParameters()
 	txId := stub.GetTxID()
 
@@ -94,12 +119,34 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	fmt.Println("  GetFunctionAndParameters() args count:", len(args))
 	fmt.Println("  GetFunctionAndParameters() args found:", args)
 
-	t.enroll_donor(stub, []string{"d1", "김현욱", "010-1234-5678"})
+	// the seed donor and recipient are only created when their private details and salts are passed in the
+	// transient map, as enroll_donor and enroll_recipient take them. The tx ID is public and no salt
+	var seed_donor DonorPrivate
+	found, err := get_optional_transient_json(stub, "donor", &seed_donor)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if found {
+		res := t.create_donor(stub, []string{"d1", "김현욱"}, seed_donor)
+		if res.Status != shim.OK {
+			return res
+		}
+	}
 	t.enroll_npo(stub, []string{"n1","프리즈밍"})
 	t.enroll_npo(stub, []string{"n2","비영리스타트업"})
 	t.enroll_npo(stub, []string{"n3","서울시NPO지원센터"})
 	t.enroll_npo(stub, []string{"n4","아름다운가게"})
-	t.enroll_recipient(stub, []string{"r1","윤지성","Permanent"})
+	var seed_recipient RecipientPrivate
+	found, err = get_optional_transient_json(stub, "recipient", &seed_recipient)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if found {
+		res := t.create_recipient(stub, []string{"r1"}, seed_recipient)
+		if res.Status != shim.OK {
+			return res
+		}
+	}
 
 
 
@@ -112,10 +159,17 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 // ============================================================================================================================
 func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	function, args := stub.GetFunctionAndParameters()
+	stub = new_invocation_stub(stub)
 	fmt.Println(" ")
 	fmt.Println("starting invoke, for - " + function)
 	fmt.Println(args)
 
+	// check the submitter's identity against the function's access rule
+	err := check_access(stub, function)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	if function == "query"{
 		return t.query(stub, args)
 	} else if function == "enroll_donor"{
@@ -144,6 +198,102 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 		return t.get_history(stub, args)
 	} else if function == "enroll_initial_needs"{
 		return t.enroll_initial_needs(stub)
+	} else if function == "get_identity" {
+		return t.get_identity(stub, args)
+	} else if function == "add_delegate" {
+		return t.add_delegate(stub, args)
+	} else if function == "remove_delegate" {
+		return t.remove_delegate(stub, args)
+	} else if function == "bind_identity" {
+		return t.bind_identity(stub, args)
+	} else if function == "read_donor_private" {
+		return t.read_donor_private(stub, args)
+	} else if function == "read_recipient_private" {
+		return t.read_recipient_private(stub, args)
+	} else if function == "migrate_keys" {
+		return t.migrate_keys(stub, args)
+	} else if function == "update_donor" {
+		return t.update_donor(stub, args)
+	} else if function == "update_npo" {
+		return t.update_npo(stub, args)
+	} else if function == "update_recipient" {
+		return t.update_recipient(stub, args)
+	} else if function == "retire_asset" {
+		return t.retire_asset(stub, args)
+	} else if function == "get_asset_transitions" {
+		return t.get_asset_transitions(stub, args)
+	} else if function == "get_allowed_actions" {
+		return t.get_allowed_actions(stub, args)
+	} else if function == "reject_asset" {
+		return t.reject_asset(stub, args)
+	} else if function == "withdraw_asset" {
+		return t.withdraw_asset(stub, args)
+	} else if function == "list_assets" {
+		return t.list_assets(stub, args)
+	} else if function == "list_donors" {
+		return t.list_donors(stub, args)
+	} else if function == "list_npos" {
+		return t.list_npos(stub, args)
+	} else if function == "list_recipients" {
+		return t.list_recipients(stub, args)
+	} else if function == "list_needs" {
+		return t.list_needs(stub, args)
+	} else if function == "query_assets" {
+		return t.query_assets(stub, args)
+	} else if function == "query_needs" {
+		return t.query_needs(stub, args)
+	} else if function == "rebuild_indexes" {
+		return t.rebuild_indexes(stub, args)
+	} else if function == "set_match_rule" {
+		return t.set_match_rule(stub, args)
+	} else if function == "update_need" {
+		return t.update_need(stub, args)
+	} else if function == "cancel_need" {
+		return t.cancel_need(stub, args)
+	} else if function == "close_need" {
+		return t.close_need(stub, args)
+	} else if function == "sweep_expired_needs" {
+		return t.sweep_expired_needs(stub, args)
+	} else if function == "renew_loan" {
+		return t.renew_loan(stub, args)
+	} else if function == "get_overdue_loans" {
+		return t.get_overdue_loans(stub, args)
+	} else if function == "list_loans" {
+		return t.list_loans(stub, args)
+	} else if function == "set_eligibility_policy" {
+		return t.set_eligibility_policy(stub, args)
+	} else if function == "confirm_receipt" {
+		return t.confirm_receipt(stub, args)
+	} else if function == "expire_receipt" {
+		return t.expire_receipt(stub, args)
+	} else if function == "adjust_credit" {
+		return t.adjust_credit(stub, args)
+	} else if function == "redeem_credit" {
+		return t.redeem_credit(stub, args)
+	} else if function == "get_credit_statement" {
+		return t.get_credit_statement(stub, args)
+	} else if function == "reconcile_credit" {
+		return t.reconcile_credit(stub, args)
+	} else if function == "set_credit_policy" {
+		return t.set_credit_policy(stub, args)
+	} else if function == "get_credit_policy" {
+		return t.get_credit_policy(stub, args)
+	} else if function == "preview_asset_credit" {
+		return t.preview_asset_credit(stub, args)
+	} else if function == "generate_receipt" {
+		return t.generate_receipt(stub, args)
+	} else if function == "get_receipts" {
+		return t.get_receipts(stub, args)
+	} else if function == "verify_receipt" {
+		return t.verify_receipt(stub, args)
+	} else if function == "get_entity_history" {
+		return t.get_entity_history(stub, args)
+	} else if function == "get_activity" {
+		return t.get_activity(stub, args)
+	} else if function == "npo_impact_report" {
+		return t.npo_impact_report(stub, args)
+	} else if function == "donor_impact" {
+		return t.donor_impact(stub, args)
 	}
 
 	// error out
@@ -151,21 +301,44 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	return shim.Error("Received unknown invoke function name - '" + function + "'")
 }
 
+// args: id, name. Transient "donor": {"phone", "salt"}
 func (t *SimpleChaincode) enroll_donor(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
+	var temp_private DonorPrivate
+	err := get_transient_json(stub, "donor", &temp_private)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return t.create_donor(stub, args, temp_private)
+}
+
+func (t *SimpleChaincode) create_donor(stub shim.ChaincodeStubInterface, args []string, temp_private DonorPrivate) pb.Response {
+
 	var temp_donor Donor  // Entities
 	var err error
 
-	if len(args) != 3 {
-		return shim.Error("Incorrect number of arguments. Expecting 3")
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if temp_private.Salt == "" {
+		return shim.Error("{\"Error\":\"Donor private details need a salt\"}")
 	}
 
 	temp_donor.ObjectType = "Donor"
-	temp_donor.Id = args[0] // d0~d999999999
+	temp_donor.Id = resolve_id(stub, "Donor", args[0]) // d0~d999999999, minted when empty
+	err = check_not_exists(stub, "Donor", temp_donor.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	temp_donor.Name = args[1]
-	temp_donor.Phone = args[2]
+	temp_donor.PII_hash = hash_pii(temp_private.Salt, temp_private.Phone)
 	temp_donor.Credit = 0
 	temp_donor.Assets_array = []string{}
+	temp_donor.IdentityBinding, err = new_identity_binding(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	fmt.Println(temp_donor)
 
@@ -173,13 +346,24 @@ func (t *SimpleChaincode) enroll_donor(stub shim.ChaincodeStubInterface, args []
 	fmt.Println("writing donor to state")
 	fmt.Println(string(donorAsBytes))
 
-	err = stub.PutState(temp_donor.Id, donorAsBytes)                    //store owner by its Id
+	err = put_state(stub, "Donor", temp_donor.Id, donorAsBytes)                    //store owner by its Id
 	if err != nil {
 		fmt.Println("Could not store donor")
 		return shim.Error(err.Error())
 	}
 
-	return shim.Success(nil)
+	temp_private.Id = temp_donor.Id
+	err = put_donor_private(stub, temp_private)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emit_event(stub, ChaincodeEvent{Name: "donor_enrolled", DonorId: temp_donor.Id})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte(temp_donor.Id))                    //return the id, minted or not
 }
 
 func (t *SimpleChaincode) enroll_npo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
@@ -193,10 +377,18 @@ func (t *SimpleChaincode) enroll_npo(stub shim.ChaincodeStubInterface, args []st
 
 
 	temp_NPO.ObjectType = "NPO"
-	temp_NPO.Id = args[0]
+	temp_NPO.Id = resolve_id(stub, "NPO", args[0])
+	err = check_not_exists(stub, "NPO", temp_NPO.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	temp_NPO.Name = args[1]
 	temp_NPO.Assets_array = []string{}
 	temp_NPO.Needs = []string{}
+	temp_NPO.IdentityBinding, err = new_identity_binding(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	fmt.Println(temp_NPO)
 
@@ -204,29 +396,56 @@ func (t *SimpleChaincode) enroll_npo(stub shim.ChaincodeStubInterface, args []st
 	fmt.Println("writing NPO information to ledger")
 	fmt.Println(string(NPOAsBytes))
 
-	err = stub.PutState(temp_NPO.Id, NPOAsBytes)                    //store owner by its Id
+	err = put_state(stub, "NPO", temp_NPO.Id, NPOAsBytes)                    //store owner by its Id
 	if err != nil {
 		fmt.Println("Could not store NPO")
 		return shim.Error(err.Error())
 	}
 
-	return shim.Success(nil)
+	err = emit_event(stub, ChaincodeEvent{Name: "npo_enrolled", NPOId: temp_NPO.Id})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte(temp_NPO.Id))                    //return the id, minted or not
 }
 
+// args: id. Transient "recipient": {"name", "type", "salt"}
 func (t *SimpleChaincode) enroll_recipient(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
+	var temp_private RecipientPrivate
+	err := get_transient_json(stub, "recipient", &temp_private)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return t.create_recipient(stub, args, temp_private)
+}
+
+func (t *SimpleChaincode) create_recipient(stub shim.ChaincodeStubInterface, args []string, temp_private RecipientPrivate) pb.Response {
+
 	var temp_rec Recipient  // Entities
 	var err error
 
-	if len(args) != 3 {
-		return shim.Error("Incorrect number of arguments. Expecting 3")
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	if temp_private.Salt == "" {
+		return shim.Error("{\"Error\":\"Recipient private details need a salt\"}")
 	}
 
 	temp_rec.ObjectType = "Recipient"
-	temp_rec.Id = args[0]
-	temp_rec.Name = args[1]
-	temp_rec.Types = args[2]
+	temp_rec.Id = resolve_id(stub, "Recipient", args[0])
+	err = check_not_exists(stub, "Recipient", temp_rec.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	temp_rec.PII_hash = hash_pii(temp_private.Salt, temp_private.Name, temp_private.Types)
 	temp_rec.Asset_array = []string{}
+	temp_rec.IdentityBinding, err = new_identity_binding(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	fmt.Println(temp_rec)
 
@@ -234,12 +453,184 @@ func (t *SimpleChaincode) enroll_recipient(stub shim.ChaincodeStubInterface, arg
 	fmt.Println("writing Recipient information to ledger")
 	fmt.Println(string(RecAsBytes))
 
-	err = stub.PutState(temp_rec.Id, RecAsBytes)  //store owner by its Id
+	err = put_state(stub, "Recipient", temp_rec.Id, RecAsBytes)  //store owner by its Id
 	if err != nil {
 		fmt.Println("Could not store Recipient")
 		return shim.Error(err.Error())
 	}
 
+	temp_private.Id = temp_rec.Id
+	err = put_recipient_private(stub, temp_private)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emit_event(stub, ChaincodeEvent{Name: "recipient_enrolled", RecipientId: temp_rec.Id})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte(temp_rec.Id))                    //return the id, minted or not
+}
+
+// args: id, name. Optional transient "donor": {"phone", "salt"} replaces the private details
+func (t *SimpleChaincode) update_donor(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	var err error
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	var temp_donor Donor
+	temp_donor_by_byte, err := get_state(stub, "Donor", args[0])
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get donor state\"}"
+		return shim.Error(jsonResp)
+	}
+	if temp_donor_by_byte == nil {
+		jsonResp := "{\"Error\":\"Donor " + args[0] + " does not exist\"}"
+		return shim.Error(jsonResp)
+	}
+	json.Unmarshal(temp_donor_by_byte, &temp_donor)
+
+	err = check_binding(stub, temp_donor.IdentityBinding, temp_donor.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// only profile fields change, credit and assets are kept
+	temp_donor.Name = args[1]
+
+	var temp_private DonorPrivate
+	found, err := get_optional_transient_json(stub, "donor", &temp_private)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if found {
+		if temp_private.Salt == "" {
+			return shim.Error("{\"Error\":\"Donor private details need a salt\"}")
+		}
+		temp_private.Id = temp_donor.Id
+		err = put_donor_private(stub, temp_private)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		temp_donor.PII_hash = hash_pii(temp_private.Salt, temp_private.Phone)
+	}
+
+	donorAsBytes, _ := json.Marshal(temp_donor)
+
+	err = put_state(stub, "Donor", temp_donor.Id, donorAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emit_event(stub, ChaincodeEvent{Name: "donor_updated", DonorId: temp_donor.Id})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// args: id, name
+func (t *SimpleChaincode) update_npo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	var err error
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	var temp_npo NPO
+	temp_npo_by_byte, err := get_state(stub, "NPO", args[0])
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get npo state\"}"
+		return shim.Error(jsonResp)
+	}
+	if temp_npo_by_byte == nil {
+		jsonResp := "{\"Error\":\"NPO " + args[0] + " does not exist\"}"
+		return shim.Error(jsonResp)
+	}
+	json.Unmarshal(temp_npo_by_byte, &temp_npo)
+
+	err = check_binding(stub, temp_npo.IdentityBinding, temp_npo.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// only profile fields change, assets and needs are kept
+	temp_npo.Name = args[1]
+
+	NPOAsBytes, _ := json.Marshal(temp_npo)
+
+	err = put_state(stub, "NPO", temp_npo.Id, NPOAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emit_event(stub, ChaincodeEvent{Name: "npo_updated", NPOId: temp_npo.Id})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// args: id. Transient "recipient": {"name", "type", "salt"} replaces the private details
+func (t *SimpleChaincode) update_recipient(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	var err error
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var temp_rec Recipient
+	temp_rec_by_byte, err := get_state(stub, "Recipient", args[0])
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get rec state\"}"
+		return shim.Error(jsonResp)
+	}
+	if temp_rec_by_byte == nil {
+		jsonResp := "{\"Error\":\"Recipient " + args[0] + " does not exist\"}"
+		return shim.Error(jsonResp)
+	}
+	json.Unmarshal(temp_rec_by_byte, &temp_rec)
+
+	err = check_binding(stub, temp_rec.IdentityBinding, temp_rec.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var temp_private RecipientPrivate
+	err = get_transient_json(stub, "recipient", &temp_private)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if temp_private.Salt == "" {
+		return shim.Error("{\"Error\":\"Recipient private details need a salt\"}")
+	}
+	temp_private.Id = temp_rec.Id
+	err = put_recipient_private(stub, temp_private)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	temp_rec.PII_hash = hash_pii(temp_private.Salt, temp_private.Name, temp_private.Types)
+
+	RecAsBytes, _ := json.Marshal(temp_rec)
+
+	err = put_state(stub, "Recipient", temp_rec.Id, RecAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emit_event(stub, ChaincodeEvent{Name: "recipient_updated", RecipientId: temp_rec.Id})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	return shim.Success(nil)
 }
 
@@ -248,14 +639,15 @@ func (t *SimpleChaincode) enroll_needs(stub shim.ChaincodeStubInterface, args []
 	var err error
 	fmt.Println("Hi")
 	fmt.Println(len(args))
-	if len(args) != 5 {
-		return shim.Error("Incorrect number of arguments. Expecting 5")
+	// id, npo id, name, product type, total count [, urgency [, tags [, deadline]]]
+	if len(args) < 5 || len(args) > 8 {
+		return shim.Error("Incorrect number of arguments. Expecting 5 to 8")
 	}
 	fmt.Println("Hello")
 	var temp_npo NPO
 	temp_npo_id := args[1]
 	fmt.Println(temp_npo_id)
-	temp_npo_by_byte, err := stub.GetState(temp_npo_id)
+	temp_npo_by_byte, err := get_state(stub, "NPO", temp_npo_id)
 	if err != nil {
 		jsonResp := "{\"Error\":\"Failed to get npo state for\"}"
 		return shim.Error(jsonResp)
@@ -270,50 +662,19 @@ func (t *SimpleChaincode) enroll_needs(stub shim.ChaincodeStubInterface, args []
 	json.Unmarshal(temp_npo_by_byte, &temp_npo)
 	fmt.Println(temp_npo)
 
-	fmt.Println("wowwowwowwow")
-
-	var temp_need Need
-
-	temp_need.Id = args[0]
-	fmt.Println("Id complete")
-	temp_need.NPOID = args[1]
-	fmt.Println("NId complete")
-	temp_need.Name = args[2]
-	fmt.Println("Id complete")
-	temp_need.ProductType = args[3]
-	fmt.Println("Id complete")
-	temp_need.Total_count,_ = strconv.Atoi(args[4])
-	fmt.Println("Id complete")
-	temp_need.Current_count = 0
-	temp_need.Status = "Incomplete"
-
-	fmt.Println(temp_need)
-
-	temp_npo.Needs = append(temp_npo.Needs, temp_need.Id)
-
-	fmt.Println(temp_npo)
-	NPOAsBytes, _ := json.Marshal(temp_npo)
-	fmt.Println("writing NPO information to ledger")
-	fmt.Println(string(NPOAsBytes))
-
-	err = stub.PutState(temp_npo.Id, NPOAsBytes)                    //store owner by its Id
+	err = check_binding(stub, temp_npo.IdentityBinding, temp_npo.Id)
 	if err != nil {
-		fmt.Println("Could not store NPO")
 		return shim.Error(err.Error())
 	}
 
-	fmt.Println(temp_need)
-	NeedsAsBytes, _ := json.Marshal(temp_need)
-	fmt.Println("writing needs information to ledger")
-	fmt.Println(string(NeedsAsBytes))
+	fmt.Println("wowwowwowwow")
 
-	err = stub.PutState(temp_need.Id, NeedsAsBytes)                    //store owner by its Id
+	temp_need, err := create_need(stub, temp_npo, args)
 	if err != nil {
-		fmt.Println("Could not store needs")
 		return shim.Error(err.Error())
 	}
 
-	return shim.Success(nil)
+	return shim.Success([]byte(temp_need.Id))                    //return the id, minted or not
 }
 
 func (t *SimpleChaincode) propose_asset(stub shim.ChaincodeStubInterface, args []string) pb.Response {
@@ -321,16 +682,21 @@ func (t *SimpleChaincode) propose_asset(stub shim.ChaincodeStubInterface, args [
 	var temp_asset Asset  // Entities
 	var err error
 
-	if len(args) != 6 {
-		return shim.Error("Incorrect number of arguments. Expecting 6")
+	// id, name, donor id, npo id, product type, picture hash [, tags [, quantity [, unit [, declared value]]]]
+	if len(args) < 6 || len(args) > 10 {
+		return shim.Error("Incorrect number of arguments. Expecting 6 to 10")
 	}
 
 	temp_asset.ObjectType = "Asset"
-	temp_asset.Id = args[0]
+	temp_asset.Id = resolve_id(stub, "Asset", args[0])
+	err = check_not_exists(stub, "Asset", temp_asset.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	temp_asset.Name = args[1]
 
 	var temp_donor Donor
-	temp_donor_by_byte, err := stub.GetState(args[2])
+	temp_donor_by_byte, err := get_state(stub, "Donor", args[2])
 	if err != nil {
 		jsonResp := "{\"Error\":\"Failed to get donor state\"}"
 		return shim.Error(jsonResp)
@@ -343,26 +709,61 @@ func (t *SimpleChaincode) propose_asset(stub shim.ChaincodeStubInterface, args [
 
 	json.Unmarshal(temp_donor_by_byte, &temp_donor)
 
+	err = check_binding(stub, temp_donor.IdentityBinding, temp_donor.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	temp_asset.DonorId = temp_donor.Id
 
 	var temp_npo NPO
-	temp_npo_by_byte, err := stub.GetState(args[3])
+	temp_npo_by_byte, err := get_state(stub, "NPO", args[3])
 	if err != nil {
 		jsonResp := "{\"Error\":\"Failed to get NPO state for \"}"
 		return shim.Error(jsonResp)
 	}
 
-	if temp_donor_by_byte == nil {
-		jsonResp := "{\"Error\":\"Nil amount for NPO state\"}"
+	if temp_npo_by_byte == nil {
+		jsonResp := "{\"Error\":\"NPO " + args[3] + " does not exist\"}"
 		return shim.Error(jsonResp)
 	}
 	json.Unmarshal(temp_npo_by_byte, &temp_npo)
 
 	temp_asset.NPOId = temp_npo.Id
 	temp_asset.Owner_history = []OwnerRelation{}
-	temp_asset.Status = "Proposed"
+	old_status := temp_asset.Status
+	err = apply_transition(stub, &temp_asset, "propose", "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	temp_asset.ProductType = args[4]
 	temp_asset.Picture = args[5]
+	temp_asset.Tags = []string{}
+	if len(args) > 6 {
+		temp_asset.Tags = parse_tags(args[6])
+	}
+	temp_asset.Quantity = 1
+	if len(args) > 7 {
+		temp_asset.Quantity, err = strconv.Atoi(args[7])
+		if err != nil || temp_asset.Quantity < 1 {
+			return shim.Error("{\"Error\":\"Quantity must be a positive number\"}")
+		}
+	}
+	temp_asset.Unit = "ea"
+	if len(args) > 8 && args[8] != "" {
+		temp_asset.Unit = args[8]
+	}
+	if len(args) > 9 && args[9] != "" {
+		temp_asset.Declared_value, err = strconv.Atoi(args[9])
+		if err != nil || temp_asset.Declared_value < 0 {
+			return shim.Error("{\"Error\":\"Declared value must be a number of won\"}")
+		}
+	}
+	txTime, err := get_tx_time(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	temp_asset.Created = txTime.Format(time.RFC3339)
 
 	fmt.Println(temp_asset)
 
@@ -370,7 +771,7 @@ func (t *SimpleChaincode) propose_asset(stub shim.ChaincodeStubInterface, args [
 	fmt.Println("writing Asset information to ledger")
 	fmt.Println(string(AssetAsBytes))
 
-	err = stub.PutState(temp_asset.Id, AssetAsBytes)                    //store owner by its Id
+	err = put_state(stub, "Asset", temp_asset.Id, AssetAsBytes)                    //store owner by its Id
 	if err != nil {
 		fmt.Println("Could not store Asset")
 		return shim.Error(err.Error())
@@ -383,7 +784,7 @@ func (t *SimpleChaincode) propose_asset(stub shim.ChaincodeStubInterface, args [
 	fmt.Println("Updating donor information to ledger")
 	fmt.Println(string(DonorAsBytes))
 
-	err = stub.PutState(temp_donor.Id, DonorAsBytes)                    //store owner by its Id
+	err = put_state(stub, "Donor", temp_donor.Id, DonorAsBytes)                    //store owner by its Id
 	if err != nil {
 		fmt.Println("Could not update donor")
 		return shim.Error(err.Error())
@@ -395,13 +796,18 @@ func (t *SimpleChaincode) propose_asset(stub shim.ChaincodeStubInterface, args [
 	fmt.Println("Updating npo information to ledger")
 	fmt.Println(string(NpoAsBytes))
 
-	err = stub.PutState(temp_npo.Id,  NpoAsBytes)                    //store owner by its Id
+	err = put_state(stub, "NPO", temp_npo.Id, NpoAsBytes)                    //store owner by its Id
 	if err != nil {
 		fmt.Println("Could not update NPO")
 		return shim.Error(err.Error())
 	}
 
-	return shim.Success(nil)
+	err = emit_event(stub, asset_event("asset_proposed", temp_asset, old_status))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte(temp_asset.Id))                    //return the id, minted or not
 }
 
 func (t *SimpleChaincode) approve_asset(stub shim.ChaincodeStubInterface, args []string) pb.Response {
@@ -413,11 +819,15 @@ func (t *SimpleChaincode) approve_asset(stub shim.ChaincodeStubInterface, args [
 	}
 
 	var temp_asset Asset
-	temp_asset_by_byte, err := stub.GetState(args[0])
+	temp_asset_by_byte, err := get_state(stub, "Asset", args[0])
 	if err != nil {
 		jsonResp := "{\"Error\":\"Failed to get Asset state\"}"
 		return shim.Error(jsonResp)
 	}
+	if temp_asset_by_byte == nil {
+		jsonResp := "{\"Error\":\"Asset " + args[0] + " does not exist\"}"
+		return shim.Error(jsonResp)
+	}
 	json.Unmarshal(temp_asset_by_byte, &temp_asset)
 
 	fmt.Println(temp_asset.NPOId)
@@ -427,90 +837,138 @@ func (t *SimpleChaincode) approve_asset(stub shim.ChaincodeStubInterface, args [
 	}
 
 	var temp_npo NPO
-	temp_npo_by_byte, err := stub.GetState(temp_asset.NPOId)
+	temp_npo_by_byte, err := get_state(stub, "NPO", temp_asset.NPOId)
 	if err != nil {
 		jsonResp := "{\"Error\":\"Failed to get npo state \"}"
 		return shim.Error(jsonResp)
 	}
+	if temp_npo_by_byte == nil {
+		jsonResp := "{\"Error\":\"NPO " + temp_asset.NPOId + " does not exist\"}"
+		return shim.Error(jsonResp)
+	}
 	json.Unmarshal(temp_npo_by_byte, &temp_npo)
 
 	fmt.Println(temp_npo)
 
-	var temp_need Need
-	check := false
-	for _, v := range temp_npo.Needs {
-		temp_npo_by_byte, err := stub.GetState(v)
-		if err != nil {
-			jsonResp := "{\"Error\":\"Failed to get needs state \"}"
-			return shim.Error(jsonResp)
-		}
-		json.Unmarshal(temp_npo_by_byte, &temp_need)
-		if temp_need.Name == temp_asset.Name {
-			temp_need.Current_count = temp_need.Current_count + 1
-			if temp_need.Current_count == temp_need.Total_count{
-				temp_need.Status = "Complete"
-			}
-			check = true
-			break
-		}
+	err = check_binding(stub, temp_npo.IdentityBinding, temp_npo.Id)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
+	allocations, leftover, err := plan_allocation(stub, temp_npo, temp_asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-	temp_asset.Status = "Approved"
+	old_status := temp_asset.Status
+	err = apply_transition(stub, &temp_asset, "approve", "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
 	fmt.Println(temp_asset)
 
-	AssetAsBytes, _ := json.Marshal(temp_asset)
-	fmt.Println("writing Asset information to ledger")
-	fmt.Println(string(AssetAsBytes))
+	// one asset per credited need, plus one for the quantity no need could take
+	parts := split_asset(temp_asset, allocations, leftover)
+	split_ids := []string{}
+	for _, part := range parts {
+		if part.Id != temp_asset.Id {
+			err = check_not_exists(stub, "Asset", part.Id)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			split_ids = append(split_ids, part.Id)
+		}
+
+		AssetAsBytes, _ := json.Marshal(part)
 
-	err = stub.PutState(temp_asset.Id, AssetAsBytes)                    //store owner by its Id
+		err = put_state(stub, "Asset", part.Id, AssetAsBytes)                    //store owner by its Id
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		err = emit_event(stub, asset_event("asset_approved", part, old_status))
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	txTime, err := get_tx_time(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	policy, err := active_credit_policy(stub, txTime)
 	if err != nil {
-		fmt.Println("Could not store Asset")
 		return shim.Error(err.Error())
 	}
+	awards := credit_awards(policy, temp_npo, parts, allocations, txTime)
 
-	if check == true {
+	for i, allocation := range allocations {
+		temp_need := allocation.Need
+		temp_need.Current_count = temp_need.Current_count + allocation.Quantity
+		temp_need.Assets = append(temp_need.Assets, parts[i].Id)
+
+		if temp_need.Current_count == temp_need.Total_count{
+			err = change_need_status(stub, &temp_need, "complete", NeedComplete, "")
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+
+			need_event := asset_event("need_completed", parts[i], NeedOpen)
+			need_event.NeedId = temp_need.Id
+			need_event.NewStatus = temp_need.Status
+			err = emit_event(stub, need_event)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+
+		NeedsAsBytes, _ := json.Marshal(temp_need)
+
+		err = put_state(stub, "Need", temp_need.Id, NeedsAsBytes)                    //store owner by its Id
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	if len(awards) > 0 || len(split_ids) > 0 {
 		var temp_donor Donor
 		temp_donor_id := temp_asset.DonorId
-		temp_donor_by_byte, err := stub.GetState(temp_donor_id)
+		temp_donor_by_byte, err := get_state(stub, "Donor", temp_donor_id)
 		if err != nil {
 			jsonResp := "{\"Error\":\"Failed to get Donor state\"}"
 			return shim.Error(jsonResp)
 		}
 		json.Unmarshal(temp_donor_by_byte, &temp_donor)
-		temp_donor.Credit = temp_donor.Credit + 1
+		err = post_credit(stub, &temp_donor, awards)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		temp_donor.Assets_array = append(temp_donor.Assets_array, split_ids...)
 		fmt.Println(temp_donor)
 
 		DonorAsBytes, _ := json.Marshal(temp_donor)
 		fmt.Println("writing Donor information to ledger")
 		fmt.Println(string(DonorAsBytes))
 
-		err = stub.PutState(temp_donor.Id, DonorAsBytes)                    //store owner by its Id
+		err = put_state(stub, "Donor", temp_donor.Id, DonorAsBytes)                    //store owner by its Id
 		if err != nil {
 			fmt.Println("Could not store Donor")
 			return shim.Error(err.Error())
 		}
+	}
 
+	if len(split_ids) > 0 {
+		temp_npo.Assets_array = append(temp_npo.Assets_array, split_ids...)
 		NpoAsBytes, _ := json.Marshal(temp_npo)
 		fmt.Println("writing Npo information to ledger")
 		fmt.Println(string(NpoAsBytes))
 
-		err = stub.PutState(temp_npo.Id, NpoAsBytes)                    //store owner by its Id
+		err = put_state(stub, "NPO", temp_npo.Id, NpoAsBytes)                    //store owner by its Id
 		if err != nil {
 			fmt.Println("Could not store Npo")
 			return shim.Error(err.Error())
 		}
-		NeedsAsBytes, _ := json.Marshal(temp_need)
-		fmt.Println("writing Needs information to ledger")
-		fmt.Println(string(NeedsAsBytes))
-
-		err = stub.PutState(temp_need.Id, NeedsAsBytes)                    //store owner by its Id
-		if err != nil {
-			fmt.Println("Could not store Needs")
-			return shim.Error(err.Error())
-		}
-
 	}
 
 	return shim.Success(nil)
@@ -525,32 +983,49 @@ func (t *SimpleChaincode) delete_asset(stub shim.ChaincodeStubInterface, args []
 	}
 
 	var temp_asset Asset
-	temp_asset_by_byte, err := stub.GetState(args[0])
+	temp_asset_by_byte, err := get_state(stub, "Asset", args[0])
 	if err != nil {
 		jsonResp := "{\"Error\":\"Failed to get Asset state \"}"
 		return shim.Error(jsonResp)
 	}
-	json.Unmarshal(temp_asset_by_byte, &temp_asset)
-
-	if temp_asset.NPOId != args[1]{
-		jsonResp := "{\"Error\":\"Asset is not owned by given NPO\"}"
+	if temp_asset_by_byte == nil {
+		jsonResp := "{\"Error\":\"Asset " + args[0] + " does not exist\"}"
 		return shim.Error(jsonResp)
 	}
+	json.Unmarshal(temp_asset_by_byte, &temp_asset)
 
-	err = stub.DelState(temp_asset.Id)                    //store owner by its Id
+	_, err = find_transition(temp_asset, "delete")
 	if err != nil {
-		fmt.Println("Could not store Asset")
 		return shim.Error(err.Error())
 	}
 
+	if temp_asset.NPOId != args[1]{
+		jsonResp := "{\"Error\":\"Asset is not owned by given NPO\"}"
+		return shim.Error(jsonResp)
+	}
+
 	var temp_npo NPO
-	temp_npo_by_byte, err := stub.GetState(args[0])
+	temp_npo_by_byte, err := get_state(stub, "NPO", temp_asset.NPOId)
 	if err != nil {
 		jsonResp := "{\"Error\":\"Failed to get Npo state \"}"
 		return shim.Error(jsonResp)
 	}
+	if temp_npo_by_byte == nil {
+		jsonResp := "{\"Error\":\"NPO " + temp_asset.NPOId + " does not exist\"}"
+		return shim.Error(jsonResp)
+	}
 	json.Unmarshal(temp_npo_by_byte, &temp_npo)
 
+	err = check_binding(stub, temp_npo.IdentityBinding, temp_npo.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = del_state(stub, "Asset", temp_asset.Id)                    //store owner by its Id
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	fmt.Println(temp_npo)
 	for i, v := range temp_npo.Assets_array {
 		if v == temp_asset.Id {
@@ -564,14 +1039,14 @@ func (t *SimpleChaincode) delete_asset(stub shim.ChaincodeStubInterface, args []
 	fmt.Println("writing NPO information to ledger")
 	fmt.Println(string(NpoAsBytes))
 
-	err = stub.PutState(temp_npo.Id, NpoAsBytes)                    //store owner by its Id
+	err = put_state(stub, "NPO", temp_npo.Id, NpoAsBytes)                    //store owner by its Id
 	if err != nil {
 		fmt.Println("Could not store NPO")
 		return shim.Error(err.Error())
 	}
 
 	var temp_donor Donor
-	temp_donor_by_byte, err := stub.GetState(temp_asset.DonorId)
+	temp_donor_by_byte, err := get_state(stub, "Donor", temp_asset.DonorId)
 	if err != nil {
 		jsonResp := "{\"Error\":\"Failed to get Donor state for \"}"
 		return shim.Error(jsonResp)
@@ -591,12 +1066,19 @@ func (t *SimpleChaincode) delete_asset(stub shim.ChaincodeStubInterface, args []
 	fmt.Println("writing Donor information to ledger")
 	fmt.Println(string(DonorAsBytes))
 
-	err = stub.PutState(temp_donor.Id, DonorAsBytes)                    //store owner by its Id
+	err = put_state(stub, "Donor", temp_donor.Id, DonorAsBytes)                    //store owner by its Id
 	if err != nil {
 		fmt.Println("Could not store Donor")
 		return shim.Error(err.Error())
 	}
 
+	deleted_event := asset_event("asset_deleted", temp_asset, temp_asset.Status)
+	deleted_event.NewStatus = StatusDeleted
+	err = emit_event(stub, deleted_event)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	return shim.Success(nil)
 }
 
@@ -604,13 +1086,21 @@ func (t *SimpleChaincode) borrow_asset(stub shim.ChaincodeStubInterface, args []
 
 	var err error
 
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 2")
+	// asset id, recipient id [, loan days]
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 3")
+	}
+	loan_days := DefaultLoanDays
+	if len(args) == 3 {
+		loan_days, err = parse_loan_days(args[2])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 	}
 
 
 	var temp_asset Asset
-	temp_asset_by_byte, err := stub.GetState(args[0])
+	temp_asset_by_byte, err := get_state(stub, "Asset", args[0])
 	if err != nil {
 		jsonResp := "{\"Error\":\"Failed to get asset state\"}"
 		return shim.Error(jsonResp)
@@ -624,35 +1114,54 @@ func (t *SimpleChaincode) borrow_asset(stub shim.ChaincodeStubInterface, args []
 	json.Unmarshal(temp_asset_by_byte, &temp_asset)
 
 	var temp_rec Recipient
-	temp_rec_by_byte, err := stub.GetState(args[1])
+	temp_rec_by_byte, err := get_state(stub, "Recipient", args[1])
 	if err != nil {
 		jsonResp := "{\"Error\":\"Failed to get rec state for \"}"
 		return shim.Error(jsonResp)
 	}
 
-	if temp_asset_by_byte == nil {
-		jsonResp := "{\"Error\":\"Nil amount rec information \"}"
+	if temp_rec_by_byte == nil {
+		jsonResp := "{\"Error\":\"Recipient " + args[1] + " does not exist\"}"
 		return shim.Error(jsonResp)
 	}
 
 	json.Unmarshal(temp_rec_by_byte, &temp_rec)
+
+	err = check_binding(stub, temp_rec.IdentityBinding, temp_rec.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = check_eligibility(stub, temp_asset, temp_rec, "borrow")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	temp_rec.Asset_array = append(temp_rec.Asset_array, temp_asset.Id)
 
 
 	var temp_owner_relation OwnerRelation
 	temp_owner_relation.Id = temp_rec.Id
-	temp_owner_relation.Username = temp_rec.Name
-	temp_owner_relation.User_type= temp_rec.Types
+	temp_owner_relation.User_type= temp_rec.ObjectType  // name and type stay in the private collection
 	fmt.Println(temp_owner_relation)
 
 	temp_asset.Owner_history = append(temp_asset.Owner_history, temp_owner_relation)
-	temp_asset.Status = "Borrowed"
+	old_status := temp_asset.Status
+	err = apply_transition(stub, &temp_asset, "borrow", "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	temp_loan, err := open_loan(stub, temp_asset, temp_rec.Id, loan_days)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	temp_asset.Loan_id = temp_loan.Id
 
 	AssetAsBytes, _ := json.Marshal(temp_asset)
 	fmt.Println("writing Asset information to ledger")
 	fmt.Println(string(AssetAsBytes))
 
-	err = stub.PutState(temp_asset.Id, AssetAsBytes)                    //store owner by its Id
+	err = put_state(stub, "Asset", temp_asset.Id, AssetAsBytes)                    //store owner by its Id
 	if err != nil {
 		fmt.Println("Could not store Asset")
 		return shim.Error(err.Error())
@@ -662,12 +1171,20 @@ func (t *SimpleChaincode) borrow_asset(stub shim.ChaincodeStubInterface, args []
 	fmt.Println("writing Rec information to ledger")
 	fmt.Println(string(RecAsBytes))
 
-	err = stub.PutState(temp_rec.Id, RecAsBytes)                    //store owner by its Id
+	err = put_state(stub, "Recipient", temp_rec.Id, RecAsBytes)                    //store owner by its Id
 	if err != nil {
 		fmt.Println("Could not store Rec")
 		return shim.Error(err.Error())
 	}
 
+	event := asset_event("asset_borrowed", temp_asset, old_status)
+	event.RecipientId = temp_rec.Id
+	event.Reason = "due " + temp_loan.Due
+	err = emit_event(stub, event)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	return shim.Success(nil)
 }
 
@@ -681,7 +1198,7 @@ func (t *SimpleChaincode) give_asset(stub shim.ChaincodeStubInterface, args []st
 
 
 	var temp_asset Asset
-	temp_asset_by_byte, err := stub.GetState(args[0])
+	temp_asset_by_byte, err := get_state(stub, "Asset", args[0])
 	if err != nil {
 		jsonResp := "{\"Error\":\"Failed to get asset state\"}"
 		return shim.Error(jsonResp)
@@ -695,35 +1212,61 @@ func (t *SimpleChaincode) give_asset(stub shim.ChaincodeStubInterface, args []st
 	json.Unmarshal(temp_asset_by_byte, &temp_asset)
 
 	var temp_rec Recipient
-	temp_rec_by_byte, err := stub.GetState(args[1])
+	temp_rec_by_byte, err := get_state(stub, "Recipient", args[1])
 	if err != nil {
 		jsonResp := "{\"Error\":\"Failed to get rec state for \"}"
 		return shim.Error(jsonResp)
 	}
 
-	if temp_asset_by_byte == nil {
-		jsonResp := "{\"Error\":\"Nil amount rec information \"}"
+	if temp_rec_by_byte == nil {
+		jsonResp := "{\"Error\":\"Recipient " + args[1] + " does not exist\"}"
 		return shim.Error(jsonResp)
 	}
 
 	json.Unmarshal(temp_rec_by_byte, &temp_rec)
+
+	var temp_npo NPO
+	temp_npo_by_byte, err := get_state(stub, "NPO", temp_asset.NPOId)
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get npo state \"}"
+		return shim.Error(jsonResp)
+	}
+	if temp_npo_by_byte == nil {
+		jsonResp := "{\"Error\":\"NPO " + temp_asset.NPOId + " does not exist\"}"
+		return shim.Error(jsonResp)
+	}
+	json.Unmarshal(temp_npo_by_byte, &temp_npo)
+
+	err = check_binding(stub, temp_npo.IdentityBinding, temp_npo.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = check_eligibility(stub, temp_asset, temp_rec, "give")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	temp_rec.Asset_array = append(temp_rec.Asset_array, temp_asset.Id)
 
 
 	var temp_owner_relation OwnerRelation
 	temp_owner_relation.Id = temp_rec.Id
-	temp_owner_relation.Username = temp_rec.Name
-	temp_owner_relation.User_type= temp_rec.Types
+	temp_owner_relation.User_type= temp_rec.ObjectType  // name and type stay in the private collection
 	fmt.Println(temp_owner_relation)
 
 	temp_asset.Owner_history = append(temp_asset.Owner_history, temp_owner_relation)
-	temp_asset.Status = "Given"
+	old_status := temp_asset.Status
+	err = apply_transition(stub, &temp_asset, "give", "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	temp_asset.RecipientId = temp_rec.Id
 
 	AssetAsBytes, _ := json.Marshal(temp_asset)
 	fmt.Println("writing Asset information to ledger")
 	fmt.Println(string(AssetAsBytes))
 
-	err = stub.PutState(temp_asset.Id, AssetAsBytes)                    //store owner by its Id
+	err = put_state(stub, "Asset", temp_asset.Id, AssetAsBytes)                    //store owner by its Id
 	if err != nil {
 		fmt.Println("Could not store Asset")
 		return shim.Error(err.Error())
@@ -733,12 +1276,19 @@ func (t *SimpleChaincode) give_asset(stub shim.ChaincodeStubInterface, args []st
 	fmt.Println("writing Rec information to ledger")
 	fmt.Println(string(RecAsBytes))
 
-	err = stub.PutState(temp_rec.Id, RecAsBytes)                    //store owner by its Id
+	err = put_state(stub, "Recipient", temp_rec.Id, RecAsBytes)                    //store owner by its Id
 	if err != nil {
 		fmt.Println("Could not store Rec")
 		return shim.Error(err.Error())
 	}
 
+	event := asset_event("asset_given", temp_asset, old_status)
+	event.RecipientId = temp_rec.Id
+	err = emit_event(stub, event)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	return shim.Success(nil)
 }
 
@@ -746,13 +1296,18 @@ func (t *SimpleChaincode) get_back_asset(stub shim.ChaincodeStubInterface, args
 
 	var err error
 
-	if len(args) != 2 {
-		return shim.Error("Incorrect number of arguments. Expecting 5")
+	// asset id, recipient id [, return condition]
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 2 or 3")
+	}
+	condition := ""
+	if len(args) == 3 {
+		condition = args[2]
 	}
 
 
 	var temp_asset Asset
-	temp_asset_by_byte, err := stub.GetState(args[0])
+	temp_asset_by_byte, err := get_state(stub, "Asset", args[0])
 	if err != nil {
 		jsonResp := "{\"Error\":\"Failed to get asset state for\"}"
 		return shim.Error(jsonResp)
@@ -766,22 +1321,70 @@ func (t *SimpleChaincode) get_back_asset(stub shim.ChaincodeStubInterface, args
 	json.Unmarshal(temp_asset_by_byte, &temp_asset)
 
 	var temp_rec Recipient
-	temp_rec_by_byte, err := stub.GetState(args[1])
+	temp_rec_by_byte, err := get_state(stub, "Recipient", args[1])
 	if err != nil {
 		jsonResp := "{\"Error\":\"Failed to get rec state \"}"
 		return shim.Error(jsonResp)
 	}
 
-	if temp_asset_by_byte == nil {
-		jsonResp := "{\"Error\":\"Nil amount rec state\"}"
+	if temp_rec_by_byte == nil {
+		jsonResp := "{\"Error\":\"Recipient " + args[1] + " does not exist\"}"
 		return shim.Error(jsonResp)
 	}
 
 	json.Unmarshal(temp_rec_by_byte, &temp_rec)
 
+	var temp_npo NPO
+	temp_npo_by_byte, err := get_state(stub, "NPO", temp_asset.NPOId)
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get npo state \"}"
+		return shim.Error(jsonResp)
+	}
+	if temp_npo_by_byte == nil {
+		jsonResp := "{\"Error\":\"NPO " + temp_asset.NPOId + " does not exist\"}"
+		return shim.Error(jsonResp)
+	}
+	json.Unmarshal(temp_npo_by_byte, &temp_npo)
 
+	err = check_binding(stub, temp_npo.IdentityBinding, temp_npo.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 
-	temp_asset.Status = "Approved"
+	// assets borrowed before loan records have only the owner history to go by
+	borrower := ""
+	if temp_asset.Loan_id != "" {
+		temp_loan, err := get_loan(stub, temp_asset.Loan_id)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		borrower = temp_loan.RecipientId
+
+		if borrower == temp_rec.Id {
+			err = close_loan(stub, temp_loan, condition)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+		}
+	} else if len(temp_asset.Owner_history) > 0 {
+		borrower = temp_asset.Owner_history[len(temp_asset.Owner_history)-1].Id
+	}
+	if temp_asset.Status == StatusBorrowed && borrower != temp_rec.Id {
+		jsonResp := "{\"Error\":\"Asset " + temp_asset.Id + " is not borrowed by recipient " + temp_rec.Id + "\"}"
+		return shim.Error(jsonResp)
+	}
+
+	old_status := temp_asset.Status
+	err = apply_transition(stub, &temp_asset, "return", condition)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	temp_asset.Loan_id = ""
+
+	var temp_owner_relation OwnerRelation
+	temp_owner_relation.Id = temp_npo.Id
+	temp_owner_relation.User_type= temp_npo.ObjectType  // back with the NPO
+	temp_asset.Owner_history = append(temp_asset.Owner_history, temp_owner_relation)
 	for i, v := range temp_rec.Asset_array {
 		if v == temp_asset.Id {
 			temp_rec.Asset_array = append(temp_rec.Asset_array[:i], temp_rec.Asset_array[i+1:]...)
@@ -793,7 +1396,7 @@ func (t *SimpleChaincode) get_back_asset(stub shim.ChaincodeStubInterface, args
 	fmt.Println("writing Asset information to ledger")
 	fmt.Println(string(AssetAsBytes))
 
-	err = stub.PutState(temp_asset.Id, AssetAsBytes)                    //store owner by its Id
+	err = put_state(stub, "Asset", temp_asset.Id, AssetAsBytes)                    //store owner by its Id
 	if err != nil {
 		fmt.Println("Could not store Asset")
 		return shim.Error(err.Error())
@@ -803,12 +1406,20 @@ func (t *SimpleChaincode) get_back_asset(stub shim.ChaincodeStubInterface, args
 	fmt.Println("writing Rec information to ledger")
 	fmt.Println(string(RecAsBytes))
 
-	err = stub.PutState(temp_rec.Id, RecAsBytes)                    //store owner by its Id
+	err = put_state(stub, "Recipient", temp_rec.Id, RecAsBytes)                    //store owner by its Id
 	if err != nil {
 		fmt.Println("Could not store Rec")
 		return shim.Error(err.Error())
 	}
 
+	event := asset_event("asset_returned", temp_asset, old_status)
+	event.RecipientId = temp_rec.Id
+	event.Reason = condition
+	err = emit_event(stub, event)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
 	return shim.Success(nil)
 }
 
@@ -817,16 +1428,41 @@ func (t *SimpleChaincode) get_back_asset(stub shim.ChaincodeStubInterface, args
 // ============================================================================================================================
 func (t *SimpleChaincode) query(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	var A string // Entities
+	var Avalbytes []byte
 	var err error
 
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting name of the person to query")
+	var Adoctype string
+
+	if len(args) == 2 {
+		// doctype, id
+		A = args[1]
+		Adoctype = args[0]
+		Avalbytes, err = get_state(stub, args[0], A)
+	} else if len(args) == 1 {
+		// id only, look it up in every doctype
+		A = args[0]
+		found := 0
+		for _, doctype := range entity_doctypes {
+			valAsBytes, getErr := get_state(stub, doctype, A)
+			if getErr != nil {
+				err = getErr
+				break
+			}
+			if valAsBytes != nil {
+				Avalbytes = valAsBytes
+				Adoctype = doctype
+				found++
+			}
+		}
+		if found > 1 {
+			jsonResp := "{\"Error\":\"" + A + " exists for several doctypes, query with doctype and id\"}"
+			return shim.Error(jsonResp)
+		}
+	} else {
+		return shim.Error("Incorrect number of arguments. Expecting [doctype,] id to query")
 	}
 
-	A = args[0]
-
 	// Get the state from the ledger
-	Avalbytes, err := stub.GetState(A)
 	if err != nil {
 		jsonResp := "{\"Error\":\"Failed to get state for " + A + "\"}"
 		return shim.Error(jsonResp)
@@ -837,6 +1473,8 @@ func (t *SimpleChaincode) query(stub shim.ChaincodeStubInterface, args []string)
 		return shim.Error(jsonResp)
 	}
 
+	Avalbytes = new_recipient_view(stub).redact_json(Adoctype, Avalbytes)
+
 	jsonResp := "{\"Name\":\"" + A + "\",\"Amount\":\"" + string(Avalbytes) + "\"}"
 	fmt.Printf("Query Response:%s\n", jsonResp)
 	return shim.Success(Avalbytes)
@@ -844,6 +1482,9 @@ func (t *SimpleChaincode) query(stub shim.ChaincodeStubInterface, args []string)
 
 
 
+// Most entities of each doctype read_everything returns, use the list_ functions beyond demo channels
+const ReadEverythingLimit = 100
+
 func (t *SimpleChaincode) read_everything(stub shim.ChaincodeStubInterface) pb.Response {
 	type Everything struct {
 		Donors   []Donor
@@ -851,17 +1492,22 @@ func (t *SimpleChaincode) read_everything(stub shim.ChaincodeStubInterface) pb.R
 		Recipients []Recipient
 		Assets []Asset
 		Needs []Need
+		Truncated bool  // some doctype had more than ReadEverythingLimit entities
 	}
 	var everything Everything
 
 	// ---- Get All Assets ---- //
-	assetsIterator, err := stub.GetStateByRange("a0", "a9999999999999999999")
+	assetsIterator, err := stub.GetStateByPartialCompositeKey("Asset", []string{})
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 	defer assetsIterator.Close()
 
 	for assetsIterator.HasNext() {
+		if len(everything.Assets) == ReadEverythingLimit {
+			everything.Truncated = true
+			break
+		}
 		aKeyValue, err := assetsIterator.Next()
 		if err != nil {
 			return shim.Error(err.Error())
@@ -877,13 +1523,17 @@ func (t *SimpleChaincode) read_everything(stub shim.ChaincodeStubInterface) pb.R
 
 
 	// ---- Get All Donors ---- //
-	donorsIterator, err := stub.GetStateByRange("d0", "d9999999999999999999")
+	donorsIterator, err := stub.GetStateByPartialCompositeKey("Donor", []string{})
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 	defer donorsIterator.Close()
 
 	for donorsIterator.HasNext() {
+		if len(everything.Donors) == ReadEverythingLimit {
+			everything.Truncated = true
+			break
+		}
 		aKeyValue, err := donorsIterator.Next()
 		if err != nil {
 			return shim.Error(err.Error())
@@ -898,13 +1548,17 @@ func (t *SimpleChaincode) read_everything(stub shim.ChaincodeStubInterface) pb.R
 	fmt.Println("donor array - ", everything.Donors)
 
 	// ---- Get All NPOs ---- //
-	nposIterator, err := stub.GetStateByRange("n0", "n9999999999999999999")
+	nposIterator, err := stub.GetStateByPartialCompositeKey("NPO", []string{})
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 	defer nposIterator.Close()
 
 	for nposIterator.HasNext() {
+		if len(everything.NPOs) == ReadEverythingLimit {
+			everything.Truncated = true
+			break
+		}
 		aKeyValue, err := nposIterator.Next()
 		if err != nil {
 			return shim.Error(err.Error())
@@ -919,13 +1573,17 @@ func (t *SimpleChaincode) read_everything(stub shim.ChaincodeStubInterface) pb.R
 	fmt.Println("NPO array - ", everything.NPOs)
 
 	// ---- Get All recipient ---- //
-	recsIterator, err := stub.GetStateByRange("r0", "r9999999999999999999")
+	recsIterator, err := stub.GetStateByPartialCompositeKey("Recipient", []string{})
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 	defer recsIterator.Close()
 
 	for recsIterator.HasNext() {
+		if len(everything.Recipients) == ReadEverythingLimit {
+			everything.Truncated = true
+			break
+		}
 		aKeyValue, err := recsIterator.Next()
 		if err != nil {
 			return shim.Error(err.Error())
@@ -941,13 +1599,17 @@ func (t *SimpleChaincode) read_everything(stub shim.ChaincodeStubInterface) pb.R
 	fmt.Println("Reciptents array - ", everything.Recipients)
 
 	// ---- Get All recipient ---- //
-	needsIterator, err := stub.GetStateByRange("e0", "e9999999999999999999")
+	needsIterator, err := stub.GetStateByPartialCompositeKey("Need", []string{})
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 	defer needsIterator.Close()
 
 	for needsIterator.HasNext() {
+		if len(everything.Needs) == ReadEverythingLimit {
+			everything.Truncated = true
+			break
+		}
 		aKeyValue, err := needsIterator.Next()
 		if err != nil {
 			return shim.Error(err.Error())
@@ -962,6 +1624,14 @@ func (t *SimpleChaincode) read_everything(stub shim.ChaincodeStubInterface) pb.R
 
 	fmt.Println("Needs array - ", everything.Needs)
 
+	view := new_recipient_view(stub)
+	for i := range everything.Assets {
+		view.redact_entity(&everything.Assets[i])
+	}
+	for i := range everything.Recipients {
+		view.redact_entity(&everything.Recipients[i])
+	}
+
 	//change to array of bytes
 	everythingAsBytes, _ := json.Marshal(everything)              //convert to array of bytes
 	return shim.Success(everythingAsBytes)
@@ -969,15 +1639,20 @@ func (t *SimpleChaincode) read_everything(stub shim.ChaincodeStubInterface) pb.R
 
 
 func (t *SimpleChaincode) get_history(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	// related entities are as they were at the entry's transaction, nil when they did not exist then
 	type AuditHistory struct {
 		TxId    string   `json:"txId"`
+		Timestamp string `json:"timestamp"`
+		IsDelete bool `json:"isDelete"`
 		Value   Asset   `json:"value"`
-		Donor_info Donor
-		Npo_info NPO
-		Recipient_info Recipient
+		Donor_info *Donor
+		Npo_info *NPO
+		Recipient_info *Recipient
+		Missing []string `json:"missing,omitempty"` // related records that could not be found
 	}
-	var history []AuditHistory;
+	history := []AuditHistory{}
 	var temp_asset Asset
+	view := new_recipient_view(stub)
 
 	if len(args) != 1 {
 		return shim.Error("Incorrect number of arguments. Expecting 1")
@@ -987,92 +1662,107 @@ func (t *SimpleChaincode) get_history(stub shim.ChaincodeStubInterface, args []s
 	fmt.Printf("- start getHistoryForAseet: %s\n", assetId)
 
 	// Get History
-	resultsIterator, err := stub.GetHistoryForKey(assetId)
+	cache := history_cache{}
+	mods, err := key_history(stub, cache, "Asset", assetId)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	defer resultsIterator.Close()
 
-	for resultsIterator.HasNext() {
-		historyData, err := resultsIterator.Next()
+	for _, historyData := range mods {
 		fmt.Println(historyData)
-		if err != nil {
-			return shim.Error(err.Error())
-		}
 
 		var tx AuditHistory
 		tx.TxId = historyData.TxId                     //copy transaction id over
-		json.Unmarshal(historyData.Value, &temp_asset)     //un stringify it aka JSON.parse()
-		if historyData.Value == nil {                  //marble has been deleted
+		tx.IsDelete = historyData.IsDelete
+		txTime := modification_time(historyData)
+		tx.Timestamp = txTime.Format(time.RFC3339)
+		if historyData.IsDelete {
+			// a deleted asset is joined with the entities of its last version
 			var emptyAsset Asset
-			tx.Value = emptyAsset                 //copy nil marble
+			tx.Value = emptyAsset
 		} else {
+			temp_asset = Asset{}
 			json.Unmarshal(historyData.Value, &temp_asset) //un stringify it aka JSON.parse()
-			tx.Value = temp_asset                      //copy marble over
+			tx.Value = temp_asset                      //copy asset over
 		}
-		var temp_donor Donor
-		temp_donor_by_byte, err := stub.GetState(tx.Value.DonorId)
+
+		temp_donor_by_byte, err := entity_as_of(stub, cache, "Donor", temp_asset.DonorId, tx.TxId, txTime)
 		if err != nil {
-			jsonResp := "{\"Error\":\"Failed to get donor state\"}"
-			return shim.Error(jsonResp)
+			return shim.Error(err.Error())
 		}
-
 		if temp_donor_by_byte == nil {
-			jsonResp := "{\"Error\":\"Nil amount for \"}"
-			return shim.Error(jsonResp)
+			tx.Missing = append(tx.Missing, "Donor " + temp_asset.DonorId)
+		} else {
+			var temp_donor Donor
+			json.Unmarshal(temp_donor_by_byte, &temp_donor)
+			tx.Donor_info = &temp_donor
 		}
 
-		json.Unmarshal(temp_donor_by_byte, &temp_donor)
-		tx.Donor_info = temp_donor
-
-		var temp_npo NPO
-		temp_npo_by_byte, err := stub.GetState(tx.Value.NPOId)
+		temp_npo_by_byte, err := entity_as_of(stub, cache, "NPO", temp_asset.NPOId, tx.TxId, txTime)
 		if err != nil {
-			jsonResp := "{\"Error\":\"Failed to get donor state\"}"
-			return shim.Error(jsonResp)
+			return shim.Error(err.Error())
 		}
-
-		if temp_donor_by_byte == nil {
-			jsonResp := "{\"Error\":\"Nil amount for \"}"
-			return shim.Error(jsonResp)
+		if temp_npo_by_byte == nil {
+			tx.Missing = append(tx.Missing, "NPO " + temp_asset.NPOId)
+		} else {
+			var temp_npo NPO
+			json.Unmarshal(temp_npo_by_byte, &temp_npo)
+			tx.Npo_info = &temp_npo
 		}
 
-		json.Unmarshal(temp_npo_by_byte, &temp_npo)
-		tx.Npo_info = temp_npo
-
-
-		if tx.Value.Status == "Given"{
-			fmt.Printf("wowwowwowowowown")
-			var temp_rec Recipient
-			temp_rec_by_byte, err := stub.GetState(tx.Value.Owner_history[0].Id)
+		// only the asset's NPO and operator admins see who held the asset
+		if view.sees_npo(temp_asset.NPOId) && (temp_asset.Status == StatusBorrowed || temp_asset.Status == StatusPendingReceipt || temp_asset.Status == StatusGiven) {
+			// both steps of the handoff carry the recipient, older assets have the owner history
+			recipientId := temp_asset.RecipientId
+			if recipientId == "" && len(temp_asset.Owner_history) > 0 {
+				recipientId = temp_asset.Owner_history[len(temp_asset.Owner_history)-1].Id
+			}
+			temp_rec_by_byte, err := entity_as_of(stub, cache, "Recipient", recipientId, tx.TxId, txTime)
 			if err != nil {
-				jsonResp := "{\"Error\":\"Failed to get rec state for \"}"
-				return shim.Error(jsonResp)
+				return shim.Error(err.Error())
 			}
-
 			if temp_rec_by_byte == nil {
-				jsonResp := "{\"Error\":\"Nil amount rec information \"}"
-				return shim.Error(jsonResp)
+				tx.Missing = append(tx.Missing, "Recipient " + recipientId)
+			} else {
+				var temp_rec Recipient
+				json.Unmarshal(temp_rec_by_byte, &temp_rec)
+				tx.Recipient_info = &temp_rec
 			}
-
-			json.Unmarshal(temp_rec_by_byte, &temp_rec)
-			tx.Recipient_info = temp_rec
-
 		}
 
+		view.redact_entity(&tx.Value)
+
 		history = append(history, tx)              //add this tx to the list
 	}
-	fmt.Printf("- getHistoryForAssets returning:\n%s", history)
 
 	//change to array of bytes
 	historyAsBytes, _ := json.Marshal(history)     //convert to array of bytes
 	fmt.Println(string(historyAsBytes))
 	return shim.Success(historyAsBytes)
 }
+// Seed needs of the seed NPOs, written directly since the NPOs have no bound identity yet
 func (t *SimpleChaincode) enroll_initial_needs(stub shim.ChaincodeStubInterface) pb.Response {
-	t.enroll_needs(stub, []string{"e1","n1","상의_티셔츠","의류","100"})
-	t.enroll_needs(stub, []string{"e2","n2","라면","음식","10000"})
-	t.enroll_needs(stub, []string{"e3","n3","교양서적","도서","1000"})
-	t.enroll_needs(stub, []string{"e4","n4","선풍기","생활가전","20"})
+	seeds := [][]string{
+		{"e1", "n1", "상의_티셔츠", "의류", "100"},
+		{"e2", "n2", "라면", "음식", "10000"},
+		{"e3", "n3", "교양서적", "도서", "1000"},
+		{"e4", "n4", "선풍기", "생활가전", "20"},
+	}
+	for _, seed := range seeds {
+		var temp_npo NPO
+		temp_npo_by_byte, err := get_state(stub, "NPO", seed[1])
+		if err != nil {
+			return shim.Error("{\"Error\":\"Failed to get npo state for " + seed[1] + "\"}")
+		}
+		if temp_npo_by_byte == nil {
+			return shim.Error("{\"Error\":\"NPO " + seed[1] + " does not exist\"}")
+		}
+		json.Unmarshal(temp_npo_by_byte, &temp_npo)
+
+		_, err = create_need(stub, temp_npo, seed)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
 	return shim.Success(nil)
 }
\ No newline at end of file
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"strings"
+	"testing"
+)
+
+func expect_already_exists(t *testing.T, res pb.Response, what string) {
+	t.Helper()
+	expect_error(t, res, what)
+	if !strings.Contains(res.Message, "already exists") {
+		t.Fatalf("%s - expected an already exists error, got %s", what, res.Message)
+	}
+}
+
+func TestCreateRejectsExistingIds(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+	expect_ok(t, s.invoke_transient(recipient_user, map[string]interface{}{"recipient": RecipientPrivate{Name: "홍길동", Types: "Temporary", Salt: "s1"}},
+		"enroll_recipient", "r100"), "enroll_recipient")
+	expect_ok(t, s.invoke(npo_user, "enroll_needs", "e100", "n100", "coats", "의류", "5"), "enroll_needs")
+	expect_ok(t, s.invoke(donor_user, "propose_asset", "a100", "coat", "d100", "n100", "의류", "hash"), "propose_asset")
+
+	res := s.invoke_transient(donor_user, map[string]interface{}{"donor": DonorPrivate{Phone: "010-0000-0002", Salt: "s2"}},
+		"enroll_donor", "d100", "someone else")
+	expect_already_exists(t, res, "enroll_donor again")
+
+	res = s.invoke(operator_admin, "enroll_npo", "n100", "someone else")
+	expect_already_exists(t, res, "enroll_npo again")
+
+	res = s.invoke_transient(recipient_user, map[string]interface{}{"recipient": RecipientPrivate{Name: "someone", Types: "Permanent", Salt: "s2"}},
+		"enroll_recipient", "r100")
+	expect_already_exists(t, res, "enroll_recipient again")
+
+	res = s.invoke(npo_user, "enroll_needs", "e100", "n100", "shoes", "신발", "3")
+	expect_already_exists(t, res, "enroll_needs again")
+
+	res = s.invoke(donor_user, "propose_asset", "a100", "hat", "d100", "n100", "의류", "hash2")
+	expect_already_exists(t, res, "propose_asset again")
+
+	// the private details were not replaced either
+	res = s.invoke(donor_user, "read_donor_private", "d100")
+	expect_ok(t, res, "read_donor_private")
+	if !strings.Contains(string(res.Payload), "010-0000-0001") {
+		t.Fatalf("donor private details replaced - %s", res.Payload)
+	}
+}
+
+func TestReenrollDoesNotEraseCredit(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+	expect_ok(t, s.invoke(donor_user, "propose_asset", "a100", "coat", "d100", "n100", "의류", "hash"), "propose_asset")
+	expect_ok(t, s.invoke(operator_admin, "adjust_credit", "d100", "30", "opening balance"), "adjust_credit")
+
+	expect_error(t, s.invoke_transient(donor_user, map[string]interface{}{"donor": DonorPrivate{Phone: "010-0000-0001", Salt: "s1"}},
+		"enroll_donor", "d100", "donor one"), "enroll_donor again")
+
+	var temp_donor Donor
+	s.read(t, "Donor", "d100", &temp_donor)
+	if temp_donor.Credit != 30 {
+		t.Fatalf("credit is %d, expected 30", temp_donor.Credit)
+	}
+	if len(temp_donor.Assets_array) != 1 || temp_donor.Assets_array[0] != "a100" {
+		t.Fatalf("assets are %v, expected [a100]", temp_donor.Assets_array)
+	}
+
+	// the profile update path keeps credit and assets
+	expect_ok(t, s.invoke(donor_user, "update_donor", "d100", "donor renamed"), "update_donor")
+	s.read(t, "Donor", "d100", &temp_donor)
+	if temp_donor.Name != "donor renamed" || temp_donor.Credit != 30 || len(temp_donor.Assets_array) != 1 {
+		t.Fatalf("update_donor lost state - %+v", temp_donor)
+	}
+}
+
+func TestReproposeDoesNotEraseHistory(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+	expect_ok(t, s.invoke(donor_user, "propose_asset", "a100", "coat", "d100", "n100", "의류", "hash"), "propose_asset")
+	expect_ok(t, s.invoke(npo_user, "approve_asset", "a100", "n100"), "approve_asset")
+
+	expect_error(t, s.invoke(donor_user, "propose_asset", "a100", "coat", "d100", "n100", "의류", "hash"), "propose_asset again")
+
+	var temp_asset Asset
+	s.read(t, "Asset", "a100", &temp_asset)
+	if temp_asset.Status != StatusApproved || len(temp_asset.Status_history) != 2 {
+		t.Fatalf("asset state lost - status %s, %d status changes", temp_asset.Status, len(temp_asset.Status_history))
+	}
+
+	res := s.invoke(donor_user, "get_history", "a100")
+	expect_ok(t, res, "get_history")
+	var history []struct {
+		Value Asset `json:"value"`
+	}
+	json.Unmarshal(res.Payload, &history)
+	if len(history) != 2 {
+		t.Fatalf("%d history entries, expected 2", len(history))
+	}
+}
+
+func TestMissingEntitiesAreRefused(t *testing.T) {
+	s := new_given_stub(t)
+
+	res := s.invoke(npo_user, "give_asset", "a100", "r999")
+	expect_error(t, res, "give_asset to a missing recipient")
+	if !strings.Contains(res.Message, "Recipient r999 does not exist") {
+		t.Fatalf("unexpected error - %s", res.Message)
+	}
+	expect_error(t, s.invoke(recipient_user, "borrow_asset", "a100", "r999"), "borrow_asset by a missing recipient")
+	expect_error(t, s.invoke(npo_user, "get_back_asset", "a100", "r999"), "get_back_asset from a missing recipient")
+
+	res = s.invoke(npo_user, "approve_asset", "a999", "n100")
+	expect_error(t, res, "approve_asset of a missing asset")
+	if !strings.Contains(res.Message, "Asset a999 does not exist") {
+		t.Fatalf("unexpected error - %s", res.Message)
+	}
+	expect_error(t, s.invoke(npo_user, "delete_asset", "a999", "n100"), "delete_asset of a missing asset")
+	expect_error(t, s.invoke(donor_user, "propose_asset", "a101", "coat", "d100", "n999", "의류", "hash"), "propose_asset to a missing NPO")
+}
+
+func TestInitialNeedsAreWrittenForTheSeedNPOs(t *testing.T) {
+	s := new_mock_stub(t)
+
+	expect_ok(t, s.invoke(operator_admin, "enroll_initial_needs"), "enroll_initial_needs")
+	var temp_need Need
+	s.read(t, "Need", "e2", &temp_need)
+	if temp_need.NPOID != "n2" || temp_need.Total_count != 10000 || temp_need.Status != NeedOpen {
+		t.Fatalf("unexpected seed need %+v", temp_need)
+	}
+	var temp_npo NPO
+	s.read(t, "NPO", "n2", &temp_npo)
+	if len(temp_npo.Needs) != 1 || temp_npo.Needs[0] != "e2" {
+		t.Fatalf("seed need not added to its NPO - %+v", temp_npo.Needs)
+	}
+
+	// run twice, the existing seeds are an error rather than skipped silently
+	expect_already_exists(t, s.invoke(operator_admin, "enroll_initial_needs"), "enroll_initial_needs again")
+}
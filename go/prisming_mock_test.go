@@ -0,0 +1,344 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// ============================================================================================================================
+// Test identities - self-signed enrollment certificates carrying the "role" attribute the way Fabric CA issues it
+// ============================================================================================================================
+var attrs_oid = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+type test_identity struct {
+	MSPID   string
+	Cert    *x509.Certificate
+	Creator []byte // serialized identity returned by GetCreator
+}
+
+func new_test_identity(mspid string, role string, name string) test_identity {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name, Organization: []string{mspid}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if role != "" {
+		attrsAsBytes, _ := json.Marshal(map[string]map[string]string{"attrs": {"role": role}})
+		template.ExtraExtensions = []pkix.Extension{{Id: attrs_oid, Value: attrsAsBytes}}
+	}
+
+	certAsBytes, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	cert, _ := x509.ParseCertificate(certAsBytes)
+
+	pemAsBytes := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certAsBytes})
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: mspid, IdBytes: pemAsBytes})
+	if err != nil {
+		panic(err)
+	}
+
+	return test_identity{MSPID: mspid, Cert: cert, Creator: creator}
+}
+
+// Same fingerprint get_caller_fingerprint computes for the identity
+func (id test_identity) fingerprint() string {
+	sum := sha256.Sum256([]byte(id.MSPID + "::" + id.Cert.Subject.String() + "::" + id.Cert.Issuer.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+var (
+	operator_admin = new_test_identity("PrismingMSP", RoleAdmin, "admin")
+	other_admin    = new_test_identity("OtherOrgMSP", RoleAdmin, "admin")
+	npo_user       = new_test_identity("NPOMSP", RoleNPO, "npo1")
+	donor_user     = new_test_identity("DonorMSP", RoleDonor, "donor1")
+	other_donor    = new_test_identity("DonorMSP", RoleDonor, "donor2")
+	recipient_user = new_test_identity("NPOMSP", RoleRecipient, "recipient1")
+	other_recip    = new_test_identity("NPOMSP", RoleRecipient, "recipient2")
+	no_role_user   = new_test_identity("DonorMSP", "", "anonymous")
+)
+
+// ============================================================================================================================
+// mock_stub - MockStub with what Fabric 1.4's mock leaves out: creator, transient map, key history, paginated
+// composite-key reads and rich queries failing the way they do on LevelDB
+// ============================================================================================================================
+type mock_stub struct {
+	*shim.MockStub
+	cc        *SimpleChaincode
+	creator   []byte
+	transient map[string][]byte
+	args      [][]byte
+	history   map[string][]*queryresult.KeyModification
+	event     []byte // payload of the last SetEvent
+	txCount   int
+}
+
+// Ledger time of the first transaction, every later one is a minute after the previous
+var mock_start = time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+// New chaincode, not yet instantiated
+func new_bare_stub() *mock_stub {
+	s := &mock_stub{cc: new(SimpleChaincode), history: map[string][]*queryresult.KeyModification{}}
+	s.MockStub = shim.NewMockStub("prisming", s.cc)
+	return s
+}
+
+// New chaincode initialised by the operator's admin, as on instantiation
+func new_mock_stub(t *testing.T) *mock_stub {
+	s := new_bare_stub()
+
+	res := s.run(operator_admin, nil, true, "init")
+	if res.Status != shim.OK {
+		t.Fatalf("Init failed - %s", res.Message)
+	}
+	return s
+}
+
+func (s *mock_stub) run(caller test_identity, transient map[string][]byte, init bool, function string, args ...string) pb.Response {
+	s.txCount++
+	txId := fmt.Sprintf("tx%04d", s.txCount)
+
+	s.args = [][]byte{[]byte(function)}
+	for _, v := range args {
+		s.args = append(s.args, []byte(v))
+	}
+	s.creator = caller.Creator
+	s.transient = transient
+	s.event = nil
+
+	s.MockTransactionStart(txId)
+	s.TxTimestamp = &timestamp.Timestamp{Seconds: mock_start.Add(time.Duration(s.txCount) * time.Minute).Unix()}
+	var res pb.Response
+	if init {
+		res = s.cc.Init(s)
+	} else {
+		res = s.cc.Invoke(s)
+	}
+	s.MockTransactionEnd(txId)
+
+	return res
+}
+
+// Invoke function as caller
+func (s *mock_stub) invoke(caller test_identity, function string, args ...string) pb.Response {
+	return s.run(caller, nil, false, function, args...)
+}
+
+// Invoke function as caller, passing transient as the proposal's transient map
+func (s *mock_stub) invoke_transient(caller test_identity, transient map[string]interface{}, function string, args ...string) pb.Response {
+	transMap := map[string][]byte{}
+	for k, v := range transient {
+		transMap[k], _ = json.Marshal(v)
+	}
+	return s.run(caller, transMap, false, function, args...)
+}
+
+func (s *mock_stub) GetCreator() ([]byte, error) {
+	return s.creator, nil
+}
+
+func (s *mock_stub) GetTransient() (map[string][]byte, error) {
+	return s.transient, nil
+}
+
+func (s *mock_stub) GetArgs() [][]byte {
+	return s.args
+}
+
+func (s *mock_stub) GetStringArgs() []string {
+	args := []string{}
+	for _, v := range s.args {
+		args = append(args, string(v))
+	}
+	return args
+}
+
+func (s *mock_stub) GetFunctionAndParameters() (string, []string) {
+	args := s.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+func (s *mock_stub) SetEvent(name string, payload []byte) error {
+	s.event = payload
+	return nil
+}
+
+// A key written twice in one transaction has a single history entry, as on the peer
+func (s *mock_stub) record(key string, value []byte, isDelete bool) {
+	mod := &queryresult.KeyModification{TxId: s.TxID, Value: value, Timestamp: s.TxTimestamp, IsDelete: isDelete}
+	mods := s.history[key]
+	if len(mods) > 0 && mods[len(mods)-1].TxId == s.TxID {
+		mods[len(mods)-1] = mod
+		return
+	}
+	s.history[key] = append(mods, mod)
+}
+
+func (s *mock_stub) PutState(key string, value []byte) error {
+	err := s.MockStub.PutState(key, value)
+	if err == nil {
+		s.record(key, value, false)
+	}
+	return err
+}
+
+func (s *mock_stub) DelState(key string) error {
+	err := s.MockStub.DelState(key)
+	if err == nil {
+		s.record(key, nil, true)
+	}
+	return err
+}
+
+// Oldest modification first, in commit order as the peer returns them
+func (s *mock_stub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	mods := append([]*queryresult.KeyModification{}, s.history[key]...)
+	return &mock_history_iterator{mods: mods}, nil
+}
+
+func (s *mock_stub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	return nil, nil, errors.New("ExecuteQuery not supported for leveldb")
+}
+
+// The bookmark is the first key of the next page
+func (s *mock_stub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	resultsIterator, err := s.MockStub.GetStateByPartialCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resultsIterator.Close()
+
+	page := &mock_kv_iterator{}
+	metadata := &pb.QueryResponseMetadata{}
+	for resultsIterator.HasNext() {
+		aKeyValue, err := resultsIterator.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if aKeyValue.Key < bookmark {
+			continue
+		}
+		if int32(len(page.kvs)) == pageSize {
+			metadata.Bookmark = aKeyValue.Key
+			break
+		}
+		page.kvs = append(page.kvs, aKeyValue)
+	}
+	metadata.FetchedRecordsCount = int32(len(page.kvs))
+
+	return page, metadata, nil
+}
+
+type mock_kv_iterator struct {
+	kvs []*queryresult.KV
+}
+
+func (it *mock_kv_iterator) HasNext() bool {
+	return len(it.kvs) > 0
+}
+
+func (it *mock_kv_iterator) Next() (*queryresult.KV, error) {
+	if len(it.kvs) == 0 {
+		return nil, errors.New("no more results")
+	}
+	kv := it.kvs[0]
+	it.kvs = it.kvs[1:]
+	return kv, nil
+}
+
+func (it *mock_kv_iterator) Close() error {
+	return nil
+}
+
+type mock_history_iterator struct {
+	mods []*queryresult.KeyModification
+}
+
+func (it *mock_history_iterator) HasNext() bool {
+	return len(it.mods) > 0
+}
+
+func (it *mock_history_iterator) Next() (*queryresult.KeyModification, error) {
+	if len(it.mods) == 0 {
+		return nil, errors.New("no more history")
+	}
+	mod := it.mods[0]
+	it.mods = it.mods[1:]
+	return mod, nil
+}
+
+func (it *mock_history_iterator) Close() error {
+	return nil
+}
+
+// ============================================================================================================================
+// Assertions
+// ============================================================================================================================
+func expect_ok(t *testing.T, res pb.Response, what string) {
+	t.Helper()
+	if res.Status != shim.OK {
+		t.Fatalf("%s failed - %s", what, res.Message)
+	}
+}
+
+func expect_error(t *testing.T, res pb.Response, what string) {
+	t.Helper()
+	if res.Status == shim.OK {
+		t.Fatalf("%s succeeded, expected an error", what)
+	}
+}
+
+// Public state of the entity of doctype with id, decoded into value
+func (s *mock_stub) read(t *testing.T, doctype string, id string, value interface{}) {
+	t.Helper()
+	key, err := s.CreateCompositeKey(doctype, []string{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	valueAsBytes := s.State[key]
+	if valueAsBytes == nil {
+		t.Fatalf("%s %s is not in state", doctype, id)
+	}
+	json.Unmarshal(valueAsBytes, value)
+}
+
+// Donor and NPO of the caller's own, the usual start of a lifecycle test
+func (s *mock_stub) enroll_test_parties(t *testing.T) {
+	t.Helper()
+	res := s.invoke_transient(donor_user, map[string]interface{}{"donor": DonorPrivate{Phone: "010-0000-0001", Salt: "s1"}},
+		"enroll_donor", "d100", "donor one")
+	expect_ok(t, res, "enroll_donor")
+	res = s.invoke(operator_admin, "enroll_npo", "n100", "npo one")
+	expect_ok(t, res, "enroll_npo")
+	res = s.invoke(operator_admin, "bind_identity", "NPO", "n100", npo_user.fingerprint())
+	expect_ok(t, res, "bind_identity")
+}
@@ -0,0 +1,538 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Need statuses, only NeedOpen needs take credit from approved assets
+const (
+	NeedOpen      = "Incomplete"
+	NeedComplete  = "Complete"
+	NeedCancelled = "Cancelled"
+	NeedClosed    = "Closed"
+	NeedExpired   = "Expired"
+)
+
+// How an NPO picks among several open needs an approved asset could count toward
+const (
+	MatchOldest  = "oldest"  // earliest enrolled need first
+	MatchUrgency = "urgency" // highest urgency first, oldest among equals
+)
+
+// Comma separated tags from a chaincode arg, blanks dropped
+func parse_tags(tagsAsString string) []string {
+	tags := []string{}
+	for _, v := range strings.Split(tagsAsString, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			tags = append(tags, v)
+		}
+	}
+	return tags
+}
+
+// An asset fits a need of the same product type, and when the need has tags, shares one of them
+func need_fits_asset(temp_need Need, temp_asset Asset) bool {
+	if temp_need.ProductType != temp_asset.ProductType {
+		return false
+	}
+	if len(temp_need.Tags) == 0 {
+		return true
+	}
+	for _, v := range temp_asset.Tags {
+		if contains(temp_need.Tags, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// A need past its deadline takes no more credit, even before sweep_expired_needs marks it
+func need_expired(temp_need Need, txTime time.Time) bool {
+	if temp_need.Deadline == "" {
+		return false
+	}
+	deadline, err := time.Parse(time.RFC3339, temp_need.Deadline)
+	return err == nil && txTime.After(deadline)
+}
+
+// Open needs of the NPO the asset can count toward, best candidate first according to the NPO's match rule
+func matching_needs(stub shim.ChaincodeStubInterface, temp_npo NPO, temp_asset Asset) ([]Need, error) {
+	candidates := []Need{}
+
+	txTime, err := get_tx_time(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range temp_npo.Needs {
+		temp_need_by_byte, err := get_state(stub, "Need", v)
+		if err != nil {
+			return nil, fmt.Errorf("{\"Error\":\"Failed to get needs state \"}")
+		}
+		if temp_need_by_byte == nil {
+			continue
+		}
+
+		var temp_need Need
+		json.Unmarshal(temp_need_by_byte, &temp_need)
+		if temp_need.Status != NeedOpen || temp_need.Current_count >= temp_need.Total_count || need_expired(temp_need, txTime) {
+			continue
+		}
+		if need_fits_asset(temp_need, temp_asset) {
+			candidates = append(candidates, temp_need)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if temp_npo.Match_rule == MatchUrgency && candidates[i].Urgency != candidates[j].Urgency {
+			return candidates[i].Urgency > candidates[j].Urgency
+		}
+		if candidates[i].Created != candidates[j].Created {
+			return candidates[i].Created < candidates[j].Created
+		}
+		return candidates[i].Id < candidates[j].Id
+	})
+
+	return candidates, nil
+}
+
+// ============================================================================================================================
+// set_match_rule - args: npo id, "oldest" or "urgency"
+// ============================================================================================================================
+func (t *SimpleChaincode) set_match_rule(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+	if args[1] != MatchOldest && args[1] != MatchUrgency {
+		return shim.Error("{\"Error\":\"Match rule must be oldest or urgency\"}")
+	}
+
+	var temp_npo NPO
+	temp_npo_by_byte, err := get_state(stub, "NPO", args[0])
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get npo state\"}"
+		return shim.Error(jsonResp)
+	}
+	if temp_npo_by_byte == nil {
+		jsonResp := "{\"Error\":\"NPO " + args[0] + " does not exist\"}"
+		return shim.Error(jsonResp)
+	}
+	json.Unmarshal(temp_npo_by_byte, &temp_npo)
+
+	err = check_binding(stub, temp_npo.IdentityBinding, temp_npo.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	temp_npo.Match_rule = args[1]
+
+	NPOAsBytes, _ := json.Marshal(temp_npo)
+
+	err = put_state(stub, "NPO", temp_npo.Id, NPOAsBytes)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = emit_event(stub, ChaincodeEvent{Name: "match_rule_set", NPOId: temp_npo.Id, Reason: temp_npo.Match_rule})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// Part of an approved asset credited to one need
+type NeedAllocation struct {
+	Need     Need `json:"need"`
+	Quantity int  `json:"quantity"`
+}
+
+// Assets proposed before quantities existed stand for a single item
+func asset_quantity(temp_asset Asset) int {
+	if temp_asset.Quantity < 1 {
+		return 1
+	}
+	return temp_asset.Quantity
+}
+
+// Spread the asset's quantity over the matching needs in order, never past a need's total.
+// Returns the allocations and the quantity no open need could take.
+func plan_allocation(stub shim.ChaincodeStubInterface, temp_npo NPO, temp_asset Asset) ([]NeedAllocation, int, error) {
+	allocations := []NeedAllocation{}
+	remaining := asset_quantity(temp_asset)
+
+	candidates, err := matching_needs(stub, temp_npo, temp_asset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, v := range candidates {
+		if remaining == 0 {
+			break
+		}
+		open := v.Total_count - v.Current_count
+		quantity := remaining
+		if quantity > open {
+			quantity = open
+		}
+		allocations = append(allocations, NeedAllocation{Need: v, Quantity: quantity})
+		remaining = remaining - quantity
+	}
+
+	return allocations, remaining, nil
+}
+
+// The asset cut into one asset per allocation, plus one for the leftover quantity.
+// The first part keeps the asset's id, the others get "<id>-<n>" and point back with Parent_id.
+func split_asset(temp_asset Asset, allocations []NeedAllocation, leftover int) []Asset {
+	parts := []Asset{}
+
+	for i, v := range allocations {
+		part := temp_asset
+		part.Quantity = v.Quantity
+		part.NeedId = v.Need.Id
+		parts = append(parts, part)
+		if i > 0 {
+			parts[i].Id = fmt.Sprintf("%s-%d", temp_asset.Id, i+1)
+			parts[i].Parent_id = temp_asset.Id
+		}
+	}
+
+	if leftover > 0 {
+		part := temp_asset
+		part.Quantity = leftover
+		part.NeedId = ""
+		if len(parts) > 0 {
+			part.Id = fmt.Sprintf("%s-%d", temp_asset.Id, len(parts)+1)
+			part.Parent_id = temp_asset.Id
+		}
+		parts = append(parts, part)
+	}
+
+	// declared value follows the quantity, the first part takes the rounding remainder
+	if len(parts) > 1 {
+		remainder := temp_asset.Declared_value
+		for i := range parts {
+			parts[i].Declared_value = temp_asset.Declared_value * parts[i].Quantity / asset_quantity(temp_asset)
+			remainder = remainder - parts[i].Declared_value
+		}
+		parts[0].Declared_value = parts[0].Declared_value + remainder
+	}
+
+	return parts
+}
+
+// Record a status change of a need, who made it and when
+func change_need_status(stub shim.ChaincodeStubInterface, temp_need *Need, action string, to string, reason string) error {
+	fingerprint, err := get_caller_fingerprint(stub)
+	if err != nil {
+		return err
+	}
+	txTime, err := get_tx_time(stub)
+	if err != nil {
+		return err
+	}
+
+	temp_need.Status_history = append(temp_need.Status_history, StatusChange{
+		Action:    action,
+		From:      temp_need.Status,
+		To:        to,
+		By:        fingerprint,
+		Timestamp: txTime.Format(time.RFC3339),
+		TxId:      stub.GetTxID(),
+		Reason:    reason,
+	})
+	temp_need.Status = to
+
+	return nil
+}
+
+// Load an open need of npoId and check the submitter is bound to that NPO
+func get_open_need(stub shim.ChaincodeStubInterface, needId string, npoId string) (Need, error) {
+	var temp_need Need
+
+	temp_need_by_byte, err := get_state(stub, "Need", needId)
+	if err != nil {
+		return temp_need, fmt.Errorf("{\"Error\":\"Failed to get needs state \"}")
+	}
+	if temp_need_by_byte == nil {
+		return temp_need, fmt.Errorf("{\"Error\":\"Need %s does not exist\"}", needId)
+	}
+	json.Unmarshal(temp_need_by_byte, &temp_need)
+
+	if temp_need.NPOID != npoId {
+		return temp_need, fmt.Errorf("{\"Error\":\"Need is not owned by given NPO\"}")
+	}
+	if temp_need.Status != NeedOpen {
+		return temp_need, fmt.Errorf("{\"Error\":\"Need %s is %s, only open needs can change\"}", needId, temp_need.Status)
+	}
+
+	var temp_npo NPO
+	temp_npo_by_byte, err := get_state(stub, "NPO", npoId)
+	if err != nil {
+		return temp_need, fmt.Errorf("{\"Error\":\"Failed to get npo state \"}")
+	}
+	json.Unmarshal(temp_npo_by_byte, &temp_npo)
+
+	err = check_binding(stub, temp_npo.IdentityBinding, temp_npo.Id)
+	if err != nil {
+		return temp_need, err
+	}
+
+	return temp_need, nil
+}
+
+// Store a need and emit the event for its status change
+func put_need(stub shim.ChaincodeStubInterface, temp_need Need, eventName string, oldStatus string, reason string) error {
+	NeedsAsBytes, _ := json.Marshal(temp_need)
+
+	err := put_state(stub, "Need", temp_need.Id, NeedsAsBytes)
+	if err != nil {
+		return err
+	}
+
+	return emit_event(stub, ChaincodeEvent{
+		Name:      eventName,
+		NPOId:     temp_need.NPOID,
+		NeedId:    temp_need.Id,
+		OldStatus: oldStatus,
+		NewStatus: temp_need.Status,
+		Reason:    reason,
+	})
+}
+
+// Deadline arg: "" for none, otherwise RFC3339
+func parse_deadline(deadline string) (string, error) {
+	if deadline == "" {
+		return "", nil
+	}
+	parsed, err := time.Parse(time.RFC3339, deadline)
+	if err != nil {
+		return "", fmt.Errorf("{\"Error\":\"Deadline must be RFC3339 - %s\"}", deadline)
+	}
+	return parsed.UTC().Format(time.RFC3339), nil
+}
+
+// Write a new open need of temp_npo and add it to the NPO's needs. args as enroll_needs takes them:
+// id, npo id, name, product type, total count [, urgency [, tags [, deadline]]]
+func create_need(stub shim.ChaincodeStubInterface, temp_npo NPO, args []string) (Need, error) {
+	var temp_need Need
+	var err error
+
+	temp_need.ObjectType = "Need"
+	temp_need.Id = resolve_id(stub, "Need", args[0])
+	err = check_not_exists(stub, "Need", temp_need.Id)
+	if err != nil {
+		return temp_need, err
+	}
+	temp_need.NPOID = temp_npo.Id
+	temp_need.Name = args[2]
+	temp_need.ProductType = args[3]
+	temp_need.Total_count, err = strconv.Atoi(args[4])
+	if err != nil || temp_need.Total_count < 1 {
+		return temp_need, fmt.Errorf("{\"Error\":\"Total count must be a positive number\"}")
+	}
+	if len(args) > 5 {
+		temp_need.Urgency, err = strconv.Atoi(args[5])
+		if err != nil {
+			return temp_need, fmt.Errorf("{\"Error\":\"Urgency must be a number\"}")
+		}
+	}
+	temp_need.Tags = []string{}
+	if len(args) > 6 {
+		temp_need.Tags = parse_tags(args[6])
+	}
+	if len(args) > 7 {
+		temp_need.Deadline, err = parse_deadline(args[7])
+		if err != nil {
+			return temp_need, err
+		}
+	}
+	temp_need.Assets = []string{}
+	err = change_need_status(stub, &temp_need, "enroll", NeedOpen, "")
+	if err != nil {
+		return temp_need, err
+	}
+	txTime, err := get_tx_time(stub)
+	if err != nil {
+		return temp_need, err
+	}
+	temp_need.Created = txTime.Format(time.RFC3339)
+
+	temp_npo.Needs = append(temp_npo.Needs, temp_need.Id)
+	NPOAsBytes, _ := json.Marshal(temp_npo)
+	err = put_state(stub, "NPO", temp_npo.Id, NPOAsBytes)
+	if err != nil {
+		return temp_need, err
+	}
+
+	NeedsAsBytes, _ := json.Marshal(temp_need)
+	err = put_state(stub, "Need", temp_need.Id, NeedsAsBytes)
+	if err != nil {
+		return temp_need, err
+	}
+
+	err = emit_event(stub, ChaincodeEvent{Name: "need_enrolled", NPOId: temp_need.NPOID, NeedId: temp_need.Id, NewStatus: temp_need.Status})
+	if err != nil {
+		return temp_need, err
+	}
+
+	return temp_need, nil
+}
+
+// ============================================================================================================================
+// update_need - args: need id, npo id, name, product type, total count [, deadline]
+// Credited assets stay credited, the total cannot drop below the current count
+// ============================================================================================================================
+func (t *SimpleChaincode) update_need(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 5 && len(args) != 6 {
+		return shim.Error("Incorrect number of arguments. Expecting 5 or 6")
+	}
+
+	temp_need, err := get_open_need(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	total, err := strconv.Atoi(args[4])
+	if err != nil || total < 1 {
+		return shim.Error("{\"Error\":\"Total count must be a positive number\"}")
+	}
+	if total < temp_need.Current_count {
+		return shim.Error("{\"Error\":\"Total count cannot be below the current count " + strconv.Itoa(temp_need.Current_count) + "\"}")
+	}
+	if len(args) == 6 {
+		temp_need.Deadline, err = parse_deadline(args[5])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	temp_need.Name = args[2]
+	temp_need.ProductType = args[3]
+	temp_need.Total_count = total
+
+	err = change_need_status(stub, &temp_need, "update", NeedOpen, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// lowering the total to the current count completes the need, recorded like a completion on approval
+	if temp_need.Current_count == temp_need.Total_count {
+		err = change_need_status(stub, &temp_need, "complete", NeedComplete, "")
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	err = put_need(stub, temp_need, "need_updated", NeedOpen, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// cancel_need and close_need: end an open need with a reason
+func end_need(stub shim.ChaincodeStubInterface, args []string, action string, to string, eventName string) pb.Response {
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+
+	temp_need, err := get_open_need(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// the need keeps its count and asset list, and the credited assets keep their needid
+	err = change_need_status(stub, &temp_need, action, to, args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = put_need(stub, temp_need, eventName, NeedOpen, args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(nil)
+}
+
+// ============================================================================================================================
+// cancel_need - args: need id, npo id, reason. The need was a mistake or is no longer wanted
+// ============================================================================================================================
+func (t *SimpleChaincode) cancel_need(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return end_need(stub, args, "cancel", NeedCancelled, "need_cancelled")
+}
+
+// ============================================================================================================================
+// close_need - args: need id, npo id, reason. The NPO stops collecting before the total is reached
+// ============================================================================================================================
+func (t *SimpleChaincode) close_need(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return end_need(stub, args, "close", NeedClosed, "need_closed")
+}
+
+// ============================================================================================================================
+// sweep_expired_needs - mark every open need past its deadline Expired, returns the expired need ids
+// ============================================================================================================================
+func (t *SimpleChaincode) sweep_expired_needs(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 0 {
+		return shim.Error("Incorrect number of arguments. Expecting 0")
+	}
+
+	txTime, err := get_tx_time(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	openIterator, err := stub.GetStateByPartialCompositeKey("Need~status", []string{NeedOpen})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer openIterator.Close()
+
+	expired := []string{}
+	for openIterator.HasNext() {
+		aKeyValue, err := openIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, keyParts, err := stub.SplitCompositeKey(aKeyValue.Key)
+		if err != nil || len(keyParts) != 2 {
+			continue
+		}
+
+		var temp_need Need
+		temp_need_by_byte, err := get_state(stub, "Need", keyParts[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if temp_need_by_byte == nil {
+			continue
+		}
+		json.Unmarshal(temp_need_by_byte, &temp_need)
+		if temp_need.Status != NeedOpen || !need_expired(temp_need, txTime) {
+			continue
+		}
+
+		err = change_need_status(stub, &temp_need, "expire", NeedExpired, "deadline "+temp_need.Deadline+" passed")
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		err = put_need(stub, temp_need, "need_expired", NeedOpen, "")
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		expired = append(expired, temp_need.Id)
+	}
+
+	expiredAsBytes, _ := json.Marshal(expired)
+	return shim.Success(expiredAsBytes)
+}
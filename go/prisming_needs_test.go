@@ -0,0 +1,212 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Propose assetId as d100 to n100 and approve it, extra args as propose_asset takes them after the picture hash
+func (s *mock_stub) donate(t *testing.T, assetId string, productType string, extra ...string) Asset {
+	t.Helper()
+	args := append([]string{assetId, "item " + assetId, "d100", "n100", productType, "hash"}, extra...)
+	expect_ok(t, s.invoke(donor_user, "propose_asset", args...), "propose_asset "+assetId)
+	expect_ok(t, s.invoke(npo_user, "approve_asset", assetId, "n100"), "approve_asset "+assetId)
+	var temp_asset Asset
+	s.read(t, "Asset", assetId, &temp_asset)
+	return temp_asset
+}
+
+func expect_need(t *testing.T, s *mock_stub, needId string, status string, count int) Need {
+	t.Helper()
+	var temp_need Need
+	s.read(t, "Need", needId, &temp_need)
+	if temp_need.Status != status || temp_need.Current_count != count {
+		t.Fatalf("need %s is %s with %d, expected %s with %d", needId, temp_need.Status, temp_need.Current_count, status, count)
+	}
+	return temp_need
+}
+
+func TestNeedsMatchByProductTypeAndTags(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+	expect_ok(t, s.invoke(npo_user, "enroll_needs", "e100", "n100", "상의_티셔츠", "의류", "1", "1"), "enroll_needs e100")
+	expect_ok(t, s.invoke(npo_user, "enroll_needs", "e101", "n100", "하의", "의류", "1", "5"), "enroll_needs e101")
+	expect_ok(t, s.invoke(npo_user, "enroll_needs", "e102", "n100", "라면", "식품", "5"), "enroll_needs e102")
+	expect_ok(t, s.invoke(npo_user, "enroll_needs", "e103", "n100", "패딩", "의류", "5", "9", "겨울"), "enroll_needs e103")
+
+	// the name no longer has to match, the oldest open need of the product type takes the asset
+	if temp_asset := s.donate(t, "a100", "의류"); temp_asset.NeedId != "e100" {
+		t.Fatalf("asset credited to %s, expected e100", temp_asset.NeedId)
+	}
+	expect_need(t, s, "e100", NeedComplete, 1)
+
+	// a complete need is skipped, a tagged need only takes assets sharing a tag
+	if temp_asset := s.donate(t, "a101", "의류", "여름"); temp_asset.NeedId != "e101" {
+		t.Fatalf("asset credited to %s, expected e101", temp_asset.NeedId)
+	}
+	expect_need(t, s, "e101", NeedComplete, 1)
+	expect_need(t, s, "e103", NeedOpen, 0)
+
+	// no open need of the product type
+	if temp_asset := s.donate(t, "a102", "가전"); temp_asset.NeedId != "" {
+		t.Fatalf("asset credited to %s without a matching need", temp_asset.NeedId)
+	}
+	expect_need(t, s, "e102", NeedOpen, 0)
+}
+
+func TestMatchRulePicksAmongOpenNeeds(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+	expect_ok(t, s.invoke(npo_user, "enroll_needs", "e100", "n100", "셔츠", "의류", "5", "1"), "enroll_needs e100")
+	expect_ok(t, s.invoke(npo_user, "enroll_needs", "e101", "n100", "바지", "의류", "5", "7"), "enroll_needs e101")
+	expect_ok(t, s.invoke(npo_user, "enroll_needs", "e102", "n100", "양말", "의류", "5", "7"), "enroll_needs e102")
+
+	if temp_asset := s.donate(t, "a100", "의류"); temp_asset.NeedId != "e100" {
+		t.Fatalf("oldest rule credited %s, expected e100", temp_asset.NeedId)
+	}
+
+	expect_error(t, s.invoke(npo_user, "set_match_rule", "n100", "newest"), "set_match_rule with an unknown rule")
+	expect_error(t, s.invoke(npo_user, "set_match_rule", "n1", MatchUrgency), "set_match_rule for another NPO")
+	expect_ok(t, s.invoke(npo_user, "set_match_rule", "n100", MatchUrgency), "set_match_rule")
+
+	// highest urgency first, the older of two equally urgent needs
+	if temp_asset := s.donate(t, "a101", "의류"); temp_asset.NeedId != "e101" {
+		t.Fatalf("urgency rule credited %s, expected e101", temp_asset.NeedId)
+	}
+	expect_need(t, s, "e100", NeedOpen, 1)
+	expect_need(t, s, "e101", NeedOpen, 1)
+	expect_need(t, s, "e102", NeedOpen, 0)
+}
+
+func TestApprovalSplitsQuantityAcrossNeeds(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+	expect_ok(t, s.invoke(npo_user, "enroll_needs", "e100", "n100", "라면", "식품", "300"), "enroll_needs e100")
+	expect_ok(t, s.invoke(npo_user, "enroll_needs", "e101", "n100", "컵라면", "식품", "100"), "enroll_needs e101")
+
+	expect_error(t, s.invoke(donor_user, "propose_asset", "a100", "라면", "d100", "n100", "식품", "hash", "", "0"), "propose_asset of no items")
+	first := s.donate(t, "a100", "식품", "", "500", "pack", "1000")
+
+	// 300 and 100 to the needs, the 100 no need could take in an asset of its own
+	if first.Quantity != 300 || first.Unit != "pack" || first.NeedId != "e100" || first.Parent_id != "" || first.Declared_value != 600 {
+		t.Fatalf("unexpected first part %+v", first)
+	}
+	var second, leftover Asset
+	s.read(t, "Asset", "a100-2", &second)
+	if second.Quantity != 100 || second.NeedId != "e101" || second.Parent_id != "a100" || second.Status != StatusApproved || second.Declared_value != 200 {
+		t.Fatalf("unexpected second part %+v", second)
+	}
+	s.read(t, "Asset", "a100-3", &leftover)
+	if leftover.Quantity != 100 || leftover.NeedId != "" || leftover.Parent_id != "a100" || leftover.Declared_value != 200 {
+		t.Fatalf("unexpected leftover %+v", leftover)
+	}
+
+	// a need never goes past its total
+	temp_need := expect_need(t, s, "e100", NeedComplete, 300)
+	expect_ids(t, temp_need.Assets, "a100")
+	temp_need = expect_need(t, s, "e101", NeedComplete, 100)
+	expect_ids(t, temp_need.Assets, "a100-2")
+
+	// credit scales with the matched quantity, the parts are listed for both parties
+	var temp_donor Donor
+	s.read(t, "Donor", "d100", &temp_donor)
+	if temp_donor.Credit != 400 {
+		t.Fatalf("donor credited %d, expected 400", temp_donor.Credit)
+	}
+	expect_ids(t, temp_donor.Assets_array, "a100", "a100-2", "a100-3")
+	var temp_npo NPO
+	s.read(t, "NPO", "n100", &temp_npo)
+	expect_ids(t, temp_npo.Assets_array, "a100", "a100-2", "a100-3")
+}
+
+func TestPartialFulfilmentKeepsTheNeedOpen(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+	expect_ok(t, s.invoke(npo_user, "enroll_needs", "e100", "n100", "라면", "식품", "10000"), "enroll_needs")
+
+	temp_asset := s.donate(t, "a100", "식품", "", "500", "pack")
+	if temp_asset.Quantity != 500 || temp_asset.NeedId != "e100" {
+		t.Fatalf("unexpected asset %+v", temp_asset)
+	}
+	key, _ := s.CreateCompositeKey("Asset", []string{"a100-2"})
+	if s.State[key] != nil {
+		t.Fatal("asset split although the need took all of it")
+	}
+	expect_need(t, s, "e100", NeedOpen, 500)
+}
+
+func TestNeedsAreUpdatedAndEnded(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+	expect_ok(t, s.invoke(npo_user, "enroll_needs", "e100", "n100", "셔츠", "의류", "3"), "enroll_needs e100")
+	expect_ok(t, s.invoke(npo_user, "enroll_needs", "e101", "n100", "라면", "식품", "5"), "enroll_needs e101")
+	s.donate(t, "a100", "의류", "", "2")
+
+	expect_error(t, s.invoke(npo_user, "update_need", "e100", "n100", "셔츠", "의류", "1"), "update_need below the current count")
+	expect_error(t, s.invoke(npo_user, "update_need", "e100", "n1", "셔츠", "의류", "4"), "update_need for another NPO")
+	expect_error(t, s.invoke(npo_user, "update_need", "e100", "n100", "셔츠", "의류", "3", "next week"), "update_need with a malformed deadline")
+	expect_ok(t, s.invoke(npo_user, "update_need", "e100", "n100", "반팔 셔츠", "의류", "4"), "update_need")
+	temp_need := expect_need(t, s, "e100", NeedOpen, 2)
+	if temp_need.Name != "반팔 셔츠" || temp_need.Total_count != 4 {
+		t.Fatalf("need not updated - %+v", temp_need)
+	}
+	// lowering the total to the count completes the need
+	expect_ok(t, s.invoke(npo_user, "update_need", "e100", "n100", "반팔 셔츠", "의류", "2"), "update_need to the current count")
+	expect_need(t, s, "e100", NeedComplete, 2)
+	expect_error(t, s.invoke(npo_user, "close_need", "e100", "n100", "enough"), "close_need of a complete need")
+
+	// a cancelled need keeps what it was credited and takes nothing more
+	s.donate(t, "a101", "식품")
+	expect_ok(t, s.invoke(npo_user, "cancel_need", "e101", "n100", "duplicate"), "cancel_need")
+	temp_need = expect_need(t, s, "e101", NeedCancelled, 1)
+	expect_ids(t, temp_need.Assets, "a101")
+	last := temp_need.Status_history[len(temp_need.Status_history)-1]
+	if last.Action != "cancel" || last.From != NeedOpen || last.Reason != "duplicate" || last.By != npo_user.fingerprint() {
+		t.Fatalf("unexpected cancellation %+v", last)
+	}
+	var temp_asset Asset
+	s.read(t, "Asset", "a101", &temp_asset)
+	if temp_asset.NeedId != "e101" {
+		t.Fatalf("credited asset lost its need - %+v", temp_asset)
+	}
+	if temp_asset = s.donate(t, "a102", "식품"); temp_asset.NeedId != "" {
+		t.Fatalf("cancelled need took %s", temp_asset.Id)
+	}
+	expect_error(t, s.invoke(npo_user, "close_need", "e101", "n100", "enough"), "close_need of a cancelled need")
+
+	expect_ok(t, s.invoke(npo_user, "enroll_needs", "e102", "n100", "양말", "의류", "9"), "enroll_needs e102")
+	expect_ok(t, s.invoke(npo_user, "close_need", "e102", "n100", "enough socks"), "close_need")
+	expect_need(t, s, "e102", NeedClosed, 0)
+}
+
+func TestExpiredNeedsAreSwept(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+	deadline := mock_start.Add(time.Hour).Format(time.RFC3339)
+	expect_ok(t, s.invoke(npo_user, "enroll_needs", "e100", "n100", "셔츠", "의류", "5", "9", "", deadline), "enroll_needs with a deadline")
+	expect_ok(t, s.invoke(npo_user, "enroll_needs", "e101", "n100", "바지", "의류", "5"), "enroll_needs without a deadline")
+
+	res := s.invoke(npo_user, "sweep_expired_needs")
+	expect_ok(t, res, "sweep_expired_needs before the deadline")
+	if string(res.Payload) != "[]" {
+		t.Fatalf("swept %s before the deadline", res.Payload)
+	}
+
+	// past the deadline the need takes no more credit, even before it is swept
+	s.txCount += 60
+	if temp_asset := s.donate(t, "a100", "의류"); temp_asset.NeedId != "e101" {
+		t.Fatalf("asset credited to %s, expected e101", temp_asset.NeedId)
+	}
+
+	expect_error(t, s.invoke(donor_user, "sweep_expired_needs"), "sweep_expired_needs as a donor")
+	res = s.invoke(npo_user, "sweep_expired_needs")
+	expect_ok(t, res, "sweep_expired_needs")
+	if string(res.Payload) != `["e100"]` {
+		t.Fatalf("unexpected expired needs %s", res.Payload)
+	}
+	temp_need := expect_need(t, s, "e100", NeedExpired, 0)
+	if temp_need.Deadline != deadline || temp_need.Status_history[len(temp_need.Status_history)-1].Action != "expire" {
+		t.Fatalf("unexpected expired need %+v", temp_need)
+	}
+	expect_need(t, s, "e101", NeedOpen, 1)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Private data collection holding donor and recipient PII, see collections_config.json
+const PIICollection = "collectionPII"
+
+// Donor fields kept off the public world state
+type DonorPrivate struct {
+	ObjectType string `json:"doctype"`
+	Id         string `json:"id"`
+	Phone      string `json:"phone"`
+	Salt       string `json:"salt"`
+}
+
+// Recipient fields kept off the public world state
+type RecipientPrivate struct {
+	ObjectType string `json:"doctype"`
+	Id         string `json:"id"`
+	Name       string `json:"name"`
+	Types      string `json:"type"`
+	Salt       string `json:"salt"`
+}
+
+// Salted hash stored on the public record so private data can be checked against it
+func hash_pii(salt string, fields ...string) string {
+	h := sha256.New()
+	h.Write([]byte(salt))
+	for _, v := range fields {
+		h.Write([]byte{0})
+		h.Write([]byte(v))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Decode the JSON value passed under key in the proposal's transient map
+func get_transient_json(stub shim.ChaincodeStubInterface, key string, value interface{}) error {
+	found, err := get_optional_transient_json(stub, key, value)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("{\"Error\":\"%s must be passed in the transient map\"}", key)
+	}
+	return nil
+}
+
+// Same as get_transient_json, but a missing key is not an error
+func get_optional_transient_json(stub shim.ChaincodeStubInterface, key string, value interface{}) (bool, error) {
+	transMap, err := stub.GetTransient()
+	if err != nil {
+		return false, fmt.Errorf("{\"Error\":\"Failed to get transient map - %s\"}", err.Error())
+	}
+
+	valueAsBytes, ok := transMap[key]
+	if !ok || len(valueAsBytes) == 0 {
+		return false, nil
+	}
+
+	err = json.Unmarshal(valueAsBytes, value)
+	if err != nil {
+		return false, fmt.Errorf("{\"Error\":\"Failed to decode transient %s - %s\"}", key, err.Error())
+	}
+
+	return true, nil
+}
+
+func put_donor_private(stub shim.ChaincodeStubInterface, temp_private DonorPrivate) error {
+	temp_private.ObjectType = "DonorPrivate"
+	privateAsBytes, _ := json.Marshal(temp_private)
+
+	key, err := entity_key(stub, "Donor", temp_private.Id)
+	if err != nil {
+		return err
+	}
+	return stub.PutPrivateData(PIICollection, key, privateAsBytes)
+}
+
+func put_recipient_private(stub shim.ChaincodeStubInterface, temp_private RecipientPrivate) error {
+	temp_private.ObjectType = "RecipientPrivate"
+	privateAsBytes, _ := json.Marshal(temp_private)
+
+	key, err := entity_key(stub, "Recipient", temp_private.Id)
+	if err != nil {
+		return err
+	}
+	return stub.PutPrivateData(PIICollection, key, privateAsBytes)
+}
+
+func get_recipient_private(stub shim.ChaincodeStubInterface, id string) (RecipientPrivate, error) {
+	var temp_private RecipientPrivate
+
+	key, err := entity_key(stub, "Recipient", id)
+	if err != nil {
+		return temp_private, err
+	}
+	privateAsBytes, err := stub.GetPrivateData(PIICollection, key)
+	if err != nil {
+		return temp_private, fmt.Errorf("{\"Error\":\"Failed to get recipient private details for %s\"}", id)
+	}
+	if privateAsBytes == nil {
+		return temp_private, fmt.Errorf("{\"Error\":\"No recipient private details for %s\"}", id)
+	}
+	json.Unmarshal(privateAsBytes, &temp_private)
+
+	return temp_private, nil
+}
+
+// Private details are read by the entity's bound identity and its delegates, e.g. the NPO that enrolled
+// a recipient, and by operator admins. A role alone is not enough, any org can issue npo or admin certificates
+func check_private_reader(stub shim.ChaincodeStubInterface, doctype string, id string) error {
+	return check_entity_binding(stub, doctype, id)
+}
+
+// ============================================================================================================================
+// read_donor_private - args: donor id. Only peers of orgs in the collection hold the data, and only the donor's
+// own identity, its delegates and operator admins may read it
+// ============================================================================================================================
+func (t *SimpleChaincode) read_donor_private(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	err := check_private_reader(stub, "Donor", args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	key, err := entity_key(stub, "Donor", args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	privateAsBytes, err := stub.GetPrivateData(PIICollection, key)
+	if err != nil {
+		jsonResp := "{\"Error\":\"Failed to get donor private details for " + args[0] + "\"}"
+		return shim.Error(jsonResp)
+	}
+	if privateAsBytes == nil {
+		jsonResp := "{\"Error\":\"No donor private details for " + args[0] + "\"}"
+		return shim.Error(jsonResp)
+	}
+
+	return shim.Success(privateAsBytes)
+}
+
+// ============================================================================================================================
+// read_recipient_private - args: recipient id. Only peers of orgs in the collection hold the data, and only the
+// recipient's own identity, its delegates and operator admins may read it
+// ============================================================================================================================
+func (t *SimpleChaincode) read_recipient_private(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	err := check_private_reader(stub, "Recipient", args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	temp_private, err := get_recipient_private(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	privateAsBytes, _ := json.Marshal(temp_private)
+	return shim.Success(privateAsBytes)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestEnrollKeepsPIIInTheCollection(t *testing.T) {
+	s := new_mock_stub(t)
+
+	res := s.invoke_transient(recipient_user, map[string]interface{}{"recipient": RecipientPrivate{Name: "홍길동", Types: "Temporary", Salt: "s1"}},
+		"enroll_recipient", "r100")
+	expect_ok(t, res, "enroll_recipient")
+
+	key, _ := s.CreateCompositeKey("Recipient", []string{"r100"})
+	publicAsBytes := string(s.State[key])
+	if strings.Contains(publicAsBytes, "홍길동") || strings.Contains(publicAsBytes, "Temporary") {
+		t.Fatalf("recipient PII in the public state - %s", publicAsBytes)
+	}
+	var temp_rec Recipient
+	s.read(t, "Recipient", "r100", &temp_rec)
+	if temp_rec.PII_hash != hash_pii("s1", "홍길동", "Temporary") {
+		t.Fatalf("unexpected PII hash %s", temp_rec.PII_hash)
+	}
+
+	var temp_private RecipientPrivate
+	json.Unmarshal(s.PvtState[PIICollection][key], &temp_private)
+	if temp_private.Name != "홍길동" || temp_private.Types != "Temporary" || temp_private.Id != "r100" {
+		t.Fatalf("unexpected private details %+v", temp_private)
+	}
+}
+
+func TestEnrollNeedsTransientDetails(t *testing.T) {
+	s := new_mock_stub(t)
+
+	res := s.invoke(donor_user, "enroll_donor", "d100", "donor one")
+	expect_error(t, res, "enroll_donor without transient details")
+	if !strings.Contains(res.Message, "transient map") {
+		t.Fatalf("unexpected error - %s", res.Message)
+	}
+	expect_error(t, s.invoke_transient(donor_user, map[string]interface{}{"donor": DonorPrivate{Phone: "010-0000-0001"}},
+		"enroll_donor", "d100", "donor one"), "enroll_donor without a salt")
+}
+
+func TestPrivateReadersAreBound(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+	expect_ok(t, s.invoke_transient(recipient_user, map[string]interface{}{"recipient": RecipientPrivate{Name: "홍길동", Types: "Temporary", Salt: "s1"}},
+		"enroll_recipient", "r100"), "enroll_recipient")
+
+	res := s.invoke(donor_user, "read_donor_private", "d100")
+	expect_ok(t, res, "read_donor_private by the donor")
+	var temp_donor_private DonorPrivate
+	json.Unmarshal(res.Payload, &temp_donor_private)
+	if temp_donor_private.Phone != "010-0000-0001" {
+		t.Fatalf("unexpected donor private details %+v", temp_donor_private)
+	}
+	expect_error(t, s.invoke(other_donor, "read_donor_private", "d100"), "read_donor_private by another donor")
+	expect_error(t, s.invoke(npo_user, "read_donor_private", "d100"), "read_donor_private by an NPO")
+	expect_error(t, s.invoke(other_admin, "read_donor_private", "d100"), "read_donor_private by another org's admin")
+	expect_ok(t, s.invoke(operator_admin, "read_donor_private", "d100"), "read_donor_private by the operator's admin")
+
+	res = s.invoke(recipient_user, "read_recipient_private", "r100")
+	expect_ok(t, res, "read_recipient_private by the recipient")
+	var temp_rec_private RecipientPrivate
+	json.Unmarshal(res.Payload, &temp_rec_private)
+	if temp_rec_private.Name != "홍길동" {
+		t.Fatalf("unexpected recipient private details %+v", temp_rec_private)
+	}
+	expect_error(t, s.invoke(other_recip, "read_recipient_private", "r100"), "read_recipient_private by another recipient")
+	expect_error(t, s.invoke(donor_user, "read_recipient_private", "r100"), "read_recipient_private by a donor")
+	expect_error(t, s.invoke(npo_user, "read_recipient_private", "r100"), "read_recipient_private by an NPO it did not enroll with")
+	expect_error(t, s.invoke(other_admin, "read_recipient_private", "r100"), "read_recipient_private by another org's admin")
+	expect_ok(t, s.invoke(operator_admin, "read_recipient_private", "r100"), "read_recipient_private by the operator's admin")
+
+	// a delegate of the recipient, e.g. its case worker, reads it too
+	expect_ok(t, s.invoke(recipient_user, "add_delegate", "Recipient", "r100", npo_user.fingerprint()), "add_delegate")
+	expect_ok(t, s.invoke(npo_user, "read_recipient_private", "r100"), "read_recipient_private by a delegate")
+}
+
+func TestUpdateReplacesPrivateDetailsAndHash(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+
+	res := s.invoke_transient(donor_user, map[string]interface{}{"donor": DonorPrivate{Phone: "010-9999-9999", Salt: "s2"}},
+		"update_donor", "d100", "donor renamed")
+	expect_ok(t, res, "update_donor")
+
+	var temp_donor Donor
+	s.read(t, "Donor", "d100", &temp_donor)
+	if temp_donor.Name != "donor renamed" || temp_donor.PII_hash != hash_pii("s2", "010-9999-9999") {
+		t.Fatalf("unexpected donor %+v", temp_donor)
+	}
+	res = s.invoke(donor_user, "read_donor_private", "d100")
+	expect_ok(t, res, "read_donor_private")
+	if !strings.Contains(string(res.Payload), "010-9999-9999") {
+		t.Fatalf("private details not replaced - %s", res.Payload)
+	}
+}
+
+func TestInitSeedsTakeSaltsFromTheTransientMap(t *testing.T) {
+	s := new_mock_stub(t)
+	key, _ := s.CreateCompositeKey("Donor", []string{"d1"})
+	if s.State[key] != nil {
+		t.Fatal("seed donor created without transient details")
+	}
+
+	s = new_bare_stub()
+	donorAsBytes, _ := json.Marshal(DonorPrivate{Phone: "010-1234-5678", Salt: "a long random salt"})
+	res := s.run(operator_admin, map[string][]byte{"donor": donorAsBytes}, true, "init")
+	expect_ok(t, res, "Init with seed details")
+
+	var temp_donor Donor
+	s.read(t, "Donor", "d1", &temp_donor)
+	if temp_donor.PII_hash != hash_pii("a long random salt", "010-1234-5678") {
+		t.Fatalf("seed donor not salted with the transient salt - %+v", temp_donor)
+	}
+}
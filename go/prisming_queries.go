@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Largest page a list query returns
+const MaxPageSize = 200
+
+// One page of a list query
+type PageResult struct {
+	Records      []json.RawMessage `json:"records"`
+	Bookmark     string            `json:"bookmark"`
+	FetchedCount int32             `json:"fetchedCount"`
+}
+
+// Page size and bookmark from list query args: pageSize [, bookmark]
+func parse_page_args(args []string) (int32, string, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return 0, "", fmt.Errorf("Incorrect number of arguments. Expecting page size and optional bookmark")
+	}
+
+	pageSize, err := strconv.Atoi(args[0])
+	if err != nil || pageSize < 1 || pageSize > MaxPageSize {
+		return 0, "", fmt.Errorf("{\"Error\":\"Page size must be between 1 and %d\"}", MaxPageSize)
+	}
+
+	bookmark := ""
+	if len(args) == 2 {
+		bookmark = args[1]
+	}
+
+	return int32(pageSize), bookmark, nil
+}
+
+// Collect the values of a query iterator into a page
+func read_page(resultsIterator shim.StateQueryIteratorInterface, metadata *pb.QueryResponseMetadata) (PageResult, error) {
+	var page PageResult
+	page.Records = []json.RawMessage{}
+
+	for resultsIterator.HasNext() {
+		aKeyValue, err := resultsIterator.Next()
+		if err != nil {
+			return page, err
+		}
+		page.Records = append(page.Records, json.RawMessage(aKeyValue.Value))
+	}
+	page.Bookmark = metadata.Bookmark
+	page.FetchedCount = metadata.FetchedRecordsCount
+
+	return page, nil
+}
+
+// One page of every entity of doctype, in key order
+func list_entities(stub shim.ChaincodeStubInterface, doctype string, args []string) pb.Response {
+	pageSize, bookmark, err := parse_page_args(args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination(doctype, []string{}, pageSize, bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	page, err := read_page(resultsIterator, metadata)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	new_recipient_view(stub).redact_page(doctype, &page)
+
+	pageAsBytes, _ := json.Marshal(page)
+	return shim.Success(pageAsBytes)
+}
+
+// ============================================================================================================================
+// list_assets, list_donors, list_npos, list_recipients, list_needs - args: page size, optional bookmark
+// ============================================================================================================================
+func (t *SimpleChaincode) list_assets(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return list_entities(stub, "Asset", args)
+}
+
+func (t *SimpleChaincode) list_donors(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return list_entities(stub, "Donor", args)
+}
+
+func (t *SimpleChaincode) list_npos(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return list_entities(stub, "NPO", args)
+}
+
+func (t *SimpleChaincode) list_recipients(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return list_entities(stub, "Recipient", args)
+}
+
+func (t *SimpleChaincode) list_needs(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return list_entities(stub, "Need", args)
+}
+
+// Filters accepted by query_assets and query_needs, From and To bound the created date (RFC3339, UTC once parsed)
+type QueryFilter struct {
+	Status      string `json:"status"`
+	ProductType string `json:"producttype"`
+	NPOId       string `json:"npoid"`
+	DonorId     string `json:"donorid"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+}
+
+// Decode the filter JSON, refusing fields outside QueryFilter
+func parse_query_filter(filterAsString string) (QueryFilter, error) {
+	var filter QueryFilter
+
+	decoder := json.NewDecoder(strings.NewReader(filterAsString))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&filter)
+	if err != nil {
+		return filter, fmt.Errorf("{\"Error\":\"Invalid filter - %s\"}", err.Error())
+	}
+
+	// stored as UTC so the bounds compare as strings with created
+	for _, v := range []*string{&filter.From, &filter.To} {
+		if *v == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, *v)
+		if err != nil {
+			return filter, fmt.Errorf("{\"Error\":\"Dates must be RFC3339 - %s\"}", *v)
+		}
+		*v = parsed.UTC().Format(time.RFC3339)
+	}
+
+	return filter, nil
+}
+
+// Indexed field/value pairs set in the filter
+func filter_fields(filter QueryFilter) map[string]string {
+	fields := map[string]string{}
+	if filter.Status != "" {
+		fields["status"] = filter.Status
+	}
+	if filter.ProductType != "" {
+		fields["producttype"] = filter.ProductType
+	}
+	if filter.NPOId != "" {
+		fields["npoid"] = filter.NPOId
+	}
+	if filter.DonorId != "" {
+		fields["donorid"] = filter.DonorId
+	}
+	return fields
+}
+
+// CouchDB selector for the filter
+func build_selector(doctype string, filter QueryFilter) string {
+	selector := map[string]interface{}{"doctype": doctype}
+	for field, value := range filter_fields(filter) {
+		selector[field] = value
+	}
+	if filter.From != "" || filter.To != "" {
+		created := map[string]string{}
+		if filter.From != "" {
+			created["$gte"] = filter.From
+		}
+		if filter.To != "" {
+			created["$lte"] = filter.To
+		}
+		selector["created"] = created
+	}
+
+	queryAsBytes, _ := json.Marshal(map[string]interface{}{"selector": selector})
+	return string(queryAsBytes)
+}
+
+// Does the stored entity satisfy the filter
+func matches_filter(valueAsBytes []byte, filter QueryFilter) bool {
+	for field, value := range filter_fields(filter) {
+		if json_field(valueAsBytes, field) != value {
+			return false
+		}
+	}
+	created := json_field(valueAsBytes, "created")
+	if filter.From != "" && created < filter.From {
+		return false
+	}
+	if filter.To != "" && created > filter.To {
+		return false
+	}
+	return true
+}
+
+// LevelDB fallback: walk the composite-key index of the first indexed filter field, or the whole doctype,
+// loading and re-checking every entity
+func query_by_index(stub shim.ChaincodeStubInterface, doctype string, filter QueryFilter, pageSize int32, bookmark string) (PageResult, error) {
+	var page PageResult
+	page.Records = []json.RawMessage{}
+
+	fields := filter_fields(filter)
+	indexName := doctype
+	indexValues := []string{}
+	for _, field := range index_fields[doctype] {
+		if value, ok := fields[field]; ok {
+			indexName = doctype + "~" + field
+			indexValues = []string{value}
+			break
+		}
+	}
+
+	resultsIterator, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination(indexName, indexValues, pageSize, bookmark)
+	if err != nil {
+		return page, err
+	}
+	defer resultsIterator.Close()
+
+	for resultsIterator.HasNext() {
+		aKeyValue, err := resultsIterator.Next()
+		if err != nil {
+			return page, err
+		}
+
+		valueAsBytes := aKeyValue.Value
+		if indexName != doctype {
+			_, keyParts, err := stub.SplitCompositeKey(aKeyValue.Key)
+			if err != nil || len(keyParts) != 2 {
+				continue
+			}
+			valueAsBytes, err = get_state(stub, doctype, keyParts[1])
+			if err != nil {
+				return page, err
+			}
+		}
+
+		// index entries can lag behind a value written twice in one tx, so check the value itself
+		if valueAsBytes != nil && matches_filter(valueAsBytes, filter) {
+			page.Records = append(page.Records, json.RawMessage(valueAsBytes))
+		}
+	}
+	page.Bookmark = metadata.Bookmark
+	page.FetchedCount = metadata.FetchedRecordsCount
+
+	return page, nil
+}
+
+// Rich query on CouchDB, falling back to the composite-key indexes when the state database is LevelDB
+func query_entities(stub shim.ChaincodeStubInterface, doctype string, filter QueryFilter, pageSize int32, bookmark string) pb.Response {
+	var page PageResult
+
+	query := build_selector(doctype, filter)
+
+	resultsIterator, metadata, err := stub.GetQueryResultWithPagination(query, pageSize, bookmark)
+	if err == nil {
+		defer resultsIterator.Close()
+		page, err = read_page(resultsIterator, metadata)
+	} else {
+		page, err = query_by_index(stub, doctype, filter, pageSize, bookmark)
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	new_recipient_view(stub).redact_page(doctype, &page)
+
+	pageAsBytes, _ := json.Marshal(page)
+	return shim.Success(pageAsBytes)
+}
+
+// ============================================================================================================================
+// query_assets - args: filter JSON {status, producttype, npoid, donorid, from, to}, page size, optional bookmark
+// ============================================================================================================================
+func (t *SimpleChaincode) query_assets(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 2 {
+		return shim.Error("Incorrect number of arguments. Expecting filter, page size and optional bookmark")
+	}
+
+	filter, err := parse_query_filter(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pageSize, bookmark, err := parse_page_args(args[1:])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return query_entities(stub, "Asset", filter, pageSize, bookmark)
+}
+
+// ============================================================================================================================
+// query_needs - args: filter JSON {status, producttype, npoid, from, to}, page size, optional bookmark
+// ============================================================================================================================
+func (t *SimpleChaincode) query_needs(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 2 {
+		return shim.Error("Incorrect number of arguments. Expecting filter, page size and optional bookmark")
+	}
+
+	filter, err := parse_query_filter(args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if filter.DonorId != "" {
+		return shim.Error("{\"Error\":\"Needs cannot be filtered by donorid\"}")
+	}
+	pageSize, bookmark, err := parse_page_args(args[1:])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return query_entities(stub, "Need", filter, pageSize, bookmark)
+}
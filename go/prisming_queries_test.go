@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// Ids of the records of a page returned by query_assets or query_needs
+func page_ids(t *testing.T, payload []byte) ([]string, string) {
+	t.Helper()
+	var page struct {
+		Records []struct {
+			Id string `json:"id"`
+		} `json:"records"`
+		Bookmark string `json:"bookmark"`
+	}
+	err := json.Unmarshal(payload, &page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, v := range page.Records {
+		ids = append(ids, v.Id)
+	}
+	return ids, page.Bookmark
+}
+
+func expect_ids(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %v, expected %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, expected %v", got, want)
+		}
+	}
+}
+
+// a100 and a102 are clothes, a101 shoes, only a100 is approved
+func new_query_stub(t *testing.T) *mock_stub {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+	expect_ok(t, s.invoke(donor_user, "propose_asset", "a100", "coat", "d100", "n100", "의류", "hash"), "propose_asset a100")
+	expect_ok(t, s.invoke(donor_user, "propose_asset", "a101", "boots", "d100", "n100", "신발", "hash"), "propose_asset a101")
+	expect_ok(t, s.invoke(donor_user, "propose_asset", "a102", "scarf", "d100", "n100", "의류", "hash"), "propose_asset a102")
+	expect_ok(t, s.invoke(npo_user, "approve_asset", "a100", "n100"), "approve_asset a100")
+	return s
+}
+
+func TestQueryAssetsFallsBackToIndexes(t *testing.T) {
+	s := new_query_stub(t)
+
+	res := s.invoke(donor_user, "query_assets", `{"status":"Approved","npoid":"n100"}`, "10")
+	expect_ok(t, res, "query_assets by status")
+	ids, bookmark := page_ids(t, res.Payload)
+	expect_ids(t, ids, "a100")
+	if bookmark != "" {
+		t.Fatalf("unexpected bookmark %s", bookmark)
+	}
+
+	res = s.invoke(donor_user, "query_assets", `{"producttype":"의류","donorid":"d100"}`, "10")
+	expect_ok(t, res, "query_assets by product type")
+	ids, _ = page_ids(t, res.Payload)
+	expect_ids(t, ids, "a100", "a102")
+
+	res = s.invoke(donor_user, "query_assets", `{"npoid":"n1"}`, "10")
+	expect_ok(t, res, "query_assets for another NPO")
+	ids, _ = page_ids(t, res.Payload)
+	expect_ids(t, ids)
+}
+
+func TestQueryAssetsPages(t *testing.T) {
+	s := new_query_stub(t)
+
+	res := s.invoke(donor_user, "query_assets", `{"npoid":"n100"}`, "2")
+	expect_ok(t, res, "query_assets first page")
+	ids, bookmark := page_ids(t, res.Payload)
+	expect_ids(t, ids, "a100", "a101")
+	if bookmark == "" {
+		t.Fatal("no bookmark on a full page")
+	}
+
+	res = s.invoke(donor_user, "query_assets", `{"npoid":"n100"}`, "2", bookmark)
+	expect_ok(t, res, "query_assets second page")
+	ids, bookmark = page_ids(t, res.Payload)
+	expect_ids(t, ids, "a102")
+	if bookmark != "" {
+		t.Fatalf("unexpected bookmark %s on the last page", bookmark)
+	}
+}
+
+func TestQueryAssetsByCreatedWindow(t *testing.T) {
+	s := new_query_stub(t)
+
+	var a101 Asset
+	s.read(t, "Asset", "a101", &a101)
+
+	// the same instant as a101's created, written in Korea Standard Time
+	res := s.invoke(donor_user, "query_assets", `{"npoid":"n100","to":"`+to_kst(t, a101.Created)+`"}`, "10")
+	expect_ok(t, res, "query_assets up to a101")
+	ids, _ := page_ids(t, res.Payload)
+	expect_ids(t, ids, "a100", "a101")
+
+	res = s.invoke(donor_user, "query_assets", `{"npoid":"n100","from":"`+to_kst(t, a101.Created)+`"}`, "10")
+	expect_ok(t, res, "query_assets from a101")
+	ids, _ = page_ids(t, res.Payload)
+	expect_ids(t, ids, "a101", "a102")
+
+	expect_error(t, s.invoke(donor_user, "query_assets", `{"from":"yesterday"}`, "10"), "query_assets with a bad date")
+}
+
+func TestQueryFilterIsConstrained(t *testing.T) {
+	s := new_query_stub(t)
+
+	expect_error(t, s.invoke(donor_user, "query_assets", `{"name":"coat"}`, "10"), "query_assets by an unknown field")
+	expect_error(t, s.invoke(npo_user, "query_needs", `{"donorid":"d100"}`, "10"), "query_needs by donor")
+	expect_error(t, s.invoke(donor_user, "query_assets", `{}`, "0"), "query_assets with page size 0")
+
+	expect_ok(t, s.invoke(npo_user, "enroll_needs", "e100", "n100", "coats", "의류", "5"), "enroll_needs")
+	res := s.invoke(npo_user, "query_needs", `{"npoid":"n100","producttype":"의류"}`, "10")
+	expect_ok(t, res, "query_needs")
+	ids, _ := page_ids(t, res.Payload)
+	expect_ids(t, ids, "e100")
+}
+
+func TestParseQueryFilterStoresUTC(t *testing.T) {
+	filter, err := parse_query_filter(`{"from":"2026-03-02T09:00:00+09:00","to":"2026-03-03T00:00:00Z"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.From != "2026-03-02T00:00:00Z" || filter.To != "2026-03-03T00:00:00Z" {
+		t.Fatalf("unexpected bounds %s, %s", filter.From, filter.To)
+	}
+}
+
+func to_kst(t *testing.T, utc string) string {
+	t.Helper()
+	parsed, err := time.Parse(time.RFC3339, utc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.In(time.FixedZone("KST", 9*60*60)).Format(time.RFC3339)
+}
+
+func TestIndexesFollowTheEntity(t *testing.T) {
+	s := new_query_stub(t)
+
+	ids, err := index_ids(s, "Asset", "status", StatusApproved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect_ids(t, ids, "a100")
+	ids, _ = index_ids(s, "Asset", "status", StatusProposed)
+	expect_ids(t, ids, "a101", "a102")
+
+	expect_ok(t, s.invoke(npo_user, "delete_asset", "a101", "n100"), "delete_asset")
+	ids, _ = index_ids(s, "Asset", "status", StatusProposed)
+	expect_ids(t, ids, "a102")
+	ids, _ = index_ids(s, "Asset", "producttype", "신발")
+	expect_ids(t, ids)
+}
+
+func TestListPagesThroughEveryEntity(t *testing.T) {
+	s := new_query_stub(t)
+
+	all := []string{}
+	bookmark := ""
+	for pages := 0; pages == 0 || bookmark != ""; pages++ {
+		if pages == 5 {
+			t.Fatal("list_npos does not end")
+		}
+		args := []string{"2"}
+		if bookmark != "" {
+			args = append(args, bookmark)
+		}
+		res := s.invoke(donor_user, "list_npos", args...)
+		expect_ok(t, res, "list_npos")
+		var ids []string
+		ids, bookmark = page_ids(t, res.Payload)
+		if len(ids) > 2 {
+			t.Fatalf("page of %d records for page size 2", len(ids))
+		}
+		all = append(all, ids...)
+	}
+	// the NPOs of Init and n100, in key order
+	expect_ids(t, all, "n1", "n100", "n2", "n3", "n4")
+
+	res := s.invoke(donor_user, "list_assets", "10")
+	expect_ok(t, res, "list_assets")
+	ids, _ := page_ids(t, res.Payload)
+	expect_ids(t, ids, "a100", "a101", "a102")
+
+	expect_error(t, s.invoke(donor_user, "list_assets", "201"), "list_assets over the page size limit")
+	expect_error(t, s.invoke(donor_user, "list_assets"), "list_assets without a page size")
+}
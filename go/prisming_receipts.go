@@ -0,0 +1,328 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Receipts follow the Korean tax year, approvals are placed in a year by their time in Korea Standard Time.
+// A fixed zone, Korea has no daylight saving and chaincode images may lack tzdata
+var ReceiptZone = time.FixedZone("KST", 9*60*60)
+
+// Yearly donation receipt of one donor for one NPO. Written once by generate_receipt and never changed,
+// Hash covers every other field so a printed receipt can be checked against the ledger.
+type Receipt struct {
+	ObjectType string        `json:"doctype"`
+	Id         string        `json:"id"` // "<npo id>-<year>-<sequence>"
+	DonorId    string        `json:"donorid"`
+	NPOId      string        `json:"npoid"`
+	Year       int           `json:"year"`     // in ReceiptZone
+	Sequence   int           `json:"sequence"` // per NPO and year, from 1
+	Items      []ReceiptItem `json:"items"`
+	Total      int           `json:"total"` // sum of the declared values in KRW
+	Issued     string        `json:"issued"`
+	By         string        `json:"by"` // fingerprint of the submitter
+	TxId       string        `json:"txId"`
+	Hash       string        `json:"hash"`
+}
+
+type ReceiptItem struct {
+	AssetId        string `json:"assetid"`
+	Name           string `json:"name"`
+	ProductType    string `json:"producttype"`
+	Quantity       int    `json:"quantity"`
+	Unit           string `json:"unit"`
+	Declared_value int    `json:"declaredvalue"`
+	Approved       string `json:"approved"` // tx time of the approval, RFC3339
+}
+
+// sha256 of the receipt's JSON with an empty Hash
+func receipt_hash(temp_receipt Receipt) string {
+	temp_receipt.Hash = ""
+	receiptAsBytes, _ := json.Marshal(temp_receipt)
+	sum := sha256.Sum256(receiptAsBytes)
+	return hex.EncodeToString(sum[:])
+}
+
+// Tx time of the asset's approval, "" when it was never approved
+func approved_at(temp_asset Asset) string {
+	for _, v := range temp_asset.Status_history {
+		if v.Action == "approve" {
+			return v.Timestamp
+		}
+	}
+	return ""
+}
+
+// Receipts already issued to the donor, through the Receipt~donorid index
+func donor_receipts(stub shim.ChaincodeStubInterface, donorId string) ([]Receipt, error) {
+	receipts := []Receipt{}
+
+	receiptsIterator, err := stub.GetStateByPartialCompositeKey("Receipt~donorid", []string{donorId})
+	if err != nil {
+		return nil, err
+	}
+	defer receiptsIterator.Close()
+
+	for receiptsIterator.HasNext() {
+		aKeyValue, err := receiptsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(aKeyValue.Key)
+		if err != nil || len(keyParts) != 2 {
+			continue
+		}
+		receiptAsBytes, err := get_state(stub, "Receipt", keyParts[1])
+		if err != nil {
+			return nil, err
+		}
+		var temp_receipt Receipt
+		json.Unmarshal(receiptAsBytes, &temp_receipt)
+		receipts = append(receipts, temp_receipt)
+	}
+
+	return receipts, nil
+}
+
+// ============================================================================================================================
+// generate_receipt - args: donor id, year. Issue one receipt per NPO for the assets approved in a finished year,
+// NPOs that already issued the donor's receipt for that year are skipped. Returns the new receipts
+// ============================================================================================================================
+func (t *SimpleChaincode) generate_receipt(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	year, err := strconv.Atoi(args[1])
+	if err != nil {
+		return shim.Error("{\"Error\":\"Year must be a number\"}")
+	}
+	txTime, err := get_tx_time(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// receipts are final, so the year must be over
+	if year >= txTime.In(ReceiptZone).Year() {
+		return shim.Error("{\"Error\":\"Receipts are issued for finished years only\"}")
+	}
+	fingerprint, err := get_caller_fingerprint(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	temp_donor, err := get_donor(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	err = check_binding(stub, temp_donor.IdentityBinding, temp_donor.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	issued := map[string]bool{}
+	receipts, err := donor_receipts(stub, temp_donor.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, v := range receipts {
+		if v.Year == year {
+			issued[v.NPOId] = true
+		}
+	}
+
+	// approved assets of the year, per NPO
+	items := map[string][]ReceiptItem{}
+	for _, v := range temp_donor.Assets_array {
+		temp_asset_by_byte, err := get_state(stub, "Asset", v)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if temp_asset_by_byte == nil {
+			continue
+		}
+		var temp_asset Asset
+		json.Unmarshal(temp_asset_by_byte, &temp_asset)
+
+		approved := approved_at(temp_asset)
+		if approved == "" || issued[temp_asset.NPOId] {
+			continue
+		}
+		approvedTime, err := time.Parse(time.RFC3339, approved)
+		if err != nil || approvedTime.In(ReceiptZone).Year() != year {
+			continue
+		}
+
+		items[temp_asset.NPOId] = append(items[temp_asset.NPOId], ReceiptItem{
+			AssetId:        temp_asset.Id,
+			Name:           temp_asset.Name,
+			ProductType:    temp_asset.ProductType,
+			Quantity:       asset_quantity(temp_asset),
+			Unit:           temp_asset.Unit,
+			Declared_value: temp_asset.Declared_value,
+			Approved:       approved,
+		})
+	}
+	if len(items) == 0 {
+		jsonResp := "{\"Error\":\"Donor " + temp_donor.Id + " has nothing left to receipt for " + args[1] + "\"}"
+		return shim.Error(jsonResp)
+	}
+
+	npoIds := []string{}
+	for k := range items {
+		npoIds = append(npoIds, k)
+	}
+	sort.Strings(npoIds)
+
+	newReceipts := []Receipt{}
+	for _, npoId := range npoIds {
+		var temp_npo NPO
+		temp_npo_by_byte, err := get_state(stub, "NPO", npoId)
+		if err != nil {
+			jsonResp := "{\"Error\":\"Failed to get npo state \"}"
+			return shim.Error(jsonResp)
+		}
+		json.Unmarshal(temp_npo_by_byte, &temp_npo)
+
+		if temp_npo.Receipt_sequences == nil {
+			temp_npo.Receipt_sequences = map[string]int{}
+		}
+		temp_npo.Receipt_sequences[args[1]] = temp_npo.Receipt_sequences[args[1]] + 1
+
+		var temp_receipt Receipt
+		temp_receipt.ObjectType = "Receipt"
+		temp_receipt.DonorId = temp_donor.Id
+		temp_receipt.NPOId = npoId
+		temp_receipt.Year = year
+		temp_receipt.Sequence = temp_npo.Receipt_sequences[args[1]]
+		temp_receipt.Id = fmt.Sprintf("%s-%d-%06d", npoId, year, temp_receipt.Sequence)
+		temp_receipt.Items = items[npoId]
+		for _, v := range temp_receipt.Items {
+			temp_receipt.Total = temp_receipt.Total + v.Declared_value
+		}
+		temp_receipt.Issued = txTime.Format(time.RFC3339)
+		temp_receipt.By = fingerprint
+		temp_receipt.TxId = stub.GetTxID()
+		temp_receipt.Hash = receipt_hash(temp_receipt)
+
+		err = check_not_exists(stub, "Receipt", temp_receipt.Id)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		ReceiptAsBytes, _ := json.Marshal(temp_receipt)
+
+		err = put_state(stub, "Receipt", temp_receipt.Id, ReceiptAsBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		NPOAsBytes, _ := json.Marshal(temp_npo)
+
+		err = put_state(stub, "NPO", temp_npo.Id, NPOAsBytes)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		err = emit_event(stub, ChaincodeEvent{
+			Name:    "receipt_issued",
+			DonorId: temp_donor.Id,
+			NPOId:   npoId,
+			Reason:  temp_receipt.Id,
+		})
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+
+		newReceipts = append(newReceipts, temp_receipt)
+	}
+
+	receiptsAsBytes, _ := json.Marshal(newReceipts)
+	return shim.Success(receiptsAsBytes)
+}
+
+// ============================================================================================================================
+// get_receipts - args: donor id [, year]. Receipts issued to the donor. The donor and operator admins read all of
+// them, an NPO only the ones it issued
+// ============================================================================================================================
+func (t *SimpleChaincode) get_receipts(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 1 or 2")
+	}
+
+	receipts, err := donor_receipts(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	donorErr := check_entity_binding(stub, "Donor", args[0])
+	if donorErr != nil {
+		issued := []Receipt{}
+		for _, v := range receipts {
+			if check_entity_binding(stub, "NPO", v.NPOId) == nil {
+				issued = append(issued, v)
+			}
+		}
+		if len(issued) == 0 {
+			return shim.Error(donorErr.Error())
+		}
+		receipts = issued
+	}
+	if len(args) == 2 {
+		year, err := strconv.Atoi(args[1])
+		if err != nil {
+			return shim.Error("{\"Error\":\"Year must be a number\"}")
+		}
+		inYear := []Receipt{}
+		for _, v := range receipts {
+			if v.Year == year {
+				inYear = append(inYear, v)
+			}
+		}
+		receipts = inYear
+	}
+
+	receiptsAsBytes, _ := json.Marshal(receipts)
+	return shim.Success(receiptsAsBytes)
+}
+
+// ============================================================================================================================
+// verify_receipt - args: receipt id, hash. Checks the hash against the stored receipt and the receipt against its own hash
+// ============================================================================================================================
+func (t *SimpleChaincode) verify_receipt(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	type ReceiptVerification struct {
+		ReceiptId string  `json:"receiptid"`
+		Valid     bool    `json:"valid"`
+		Hash      string  `json:"hash"` // hash recomputed from the ledger
+		Receipt   Receipt `json:"receipt"`
+	}
+
+	if len(args) != 2 {
+		return shim.Error("Incorrect number of arguments. Expecting 2")
+	}
+
+	receiptAsBytes, err := get_state(stub, "Receipt", args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if receiptAsBytes == nil {
+		jsonResp := "{\"Error\":\"Receipt " + args[0] + " does not exist\"}"
+		return shim.Error(jsonResp)
+	}
+
+	var result ReceiptVerification
+	json.Unmarshal(receiptAsBytes, &result.Receipt)
+	result.ReceiptId = result.Receipt.Id
+	result.Hash = receipt_hash(result.Receipt)
+	result.Valid = result.Hash == result.Receipt.Hash && result.Hash == args[1]
+
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// Run the next transactions at the given ledger time
+func (s *mock_stub) set_time(at time.Time) {
+	s.txCount = int(at.Sub(mock_start).Minutes()) - 1
+}
+
+func receipt_ids(t *testing.T, payload []byte) []string {
+	t.Helper()
+	var receipts []Receipt
+	err := json.Unmarshal(payload, &receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, v := range receipts {
+		ids = append(ids, v.Id)
+	}
+	return ids
+}
+
+// d100 gave a100 and a101 to n100 and a102 to n1 in 2026, and a103 to n100 on new year's eve, past midnight in Korea.
+// d101 of other_donor gave a104 to n100 in 2026. The ledger time is then 2027
+func new_receipt_stub(t *testing.T) *mock_stub {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+	expect_ok(t, s.invoke_transient(other_donor, map[string]interface{}{"donor": DonorPrivate{Phone: "010-0000-0002", Salt: "s2"}},
+		"enroll_donor", "d101", "donor two"), "enroll_donor d101")
+
+	s.donate(t, "a100", "의류", "", "1", "", "30000")
+	s.donate(t, "a101", "의류", "", "2", "", "20000")
+	expect_ok(t, s.invoke(donor_user, "propose_asset", "a102", "책", "d100", "n1", "도서", "hash", "", "1", "", "5000"), "propose_asset a102")
+	expect_ok(t, s.invoke(operator_admin, "approve_asset", "a102", "n1"), "approve_asset a102")
+	expect_ok(t, s.invoke(other_donor, "propose_asset", "a104", "책", "d101", "n100", "도서", "hash", "", "1", "", "7000"), "propose_asset a104")
+	expect_ok(t, s.invoke(npo_user, "approve_asset", "a104", "n100"), "approve_asset a104")
+
+	expect_error(t, s.invoke(donor_user, "generate_receipt", "d100", "2026"), "generate_receipt before the year is over")
+
+	s.set_time(time.Date(2026, 12, 31, 15, 0, 0, 0, time.UTC))
+	s.donate(t, "a103", "의류", "", "1", "", "1000")
+
+	s.set_time(time.Date(2027, 1, 10, 0, 0, 0, 0, time.UTC))
+	return s
+}
+
+func TestReceiptsArePerNPOAndYear(t *testing.T) {
+	s := new_receipt_stub(t)
+
+	expect_error(t, s.invoke(other_donor, "generate_receipt", "d100", "2026"), "generate_receipt for another donor")
+	expect_error(t, s.invoke(npo_user, "generate_receipt", "d100", "2026"), "generate_receipt by an NPO")
+	res := s.invoke(donor_user, "generate_receipt", "d100", "2026")
+	expect_ok(t, res, "generate_receipt")
+	expect_ids(t, receipt_ids(t, res.Payload), "n1-2026-000001", "n100-2026-000001")
+
+	var temp_receipt Receipt
+	s.read(t, "Receipt", "n100-2026-000001", &temp_receipt)
+	items := []string{}
+	for _, v := range temp_receipt.Items {
+		items = append(items, v.AssetId)
+	}
+	// a103 was approved in 2027 in Korea
+	expect_ids(t, items, "a100", "a101")
+	if temp_receipt.Total != 50000 || temp_receipt.Sequence != 1 || temp_receipt.Items[1].Quantity != 2 || temp_receipt.Hash != receipt_hash(temp_receipt) {
+		t.Fatalf("unexpected receipt %+v", temp_receipt)
+	}
+
+	// issued receipts are final, the NPO numbers the next donor's receipt on
+	expect_error(t, s.invoke(donor_user, "generate_receipt", "d100", "2026"), "generate_receipt twice")
+	res = s.invoke(other_donor, "generate_receipt", "d101", "2026")
+	expect_ok(t, res, "generate_receipt d101")
+	expect_ids(t, receipt_ids(t, res.Payload), "n100-2026-000002")
+	var temp_npo NPO
+	s.read(t, "NPO", "n100", &temp_npo)
+	if temp_npo.Receipt_sequences["2026"] != 2 {
+		t.Fatalf("unexpected receipt sequences %+v", temp_npo.Receipt_sequences)
+	}
+	expect_error(t, s.invoke(donor_user, "generate_receipt", "d100", "2027"), "generate_receipt for the current year")
+}
+
+func TestReceiptsAreVerifiedByHash(t *testing.T) {
+	s := new_receipt_stub(t)
+	expect_ok(t, s.invoke(donor_user, "generate_receipt", "d100", "2026"), "generate_receipt")
+	var temp_receipt Receipt
+	s.read(t, "Receipt", "n100-2026-000001", &temp_receipt)
+
+	verify := func(hash string) bool {
+		res := s.invoke(other_donor, "verify_receipt", temp_receipt.Id, hash)
+		expect_ok(t, res, "verify_receipt")
+		var result struct {
+			Valid bool `json:"valid"`
+		}
+		json.Unmarshal(res.Payload, &result)
+		return result.Valid
+	}
+	if !verify(temp_receipt.Hash) {
+		t.Fatal("issued receipt does not verify")
+	}
+	if verify(receipt_hash(Receipt{})) {
+		t.Fatal("receipt verified against another hash")
+	}
+
+	// a receipt changed outside generate_receipt no longer matches its hash
+	temp_receipt.Total = 90000
+	receiptAsBytes, _ := json.Marshal(temp_receipt)
+	key, _ := s.CreateCompositeKey("Receipt", []string{temp_receipt.Id})
+	s.put_legacy(t, key, string(receiptAsBytes))
+	if verify(temp_receipt.Hash) {
+		t.Fatal("tampered receipt verified")
+	}
+	expect_error(t, s.invoke(other_donor, "verify_receipt", "n100-2026-000009", temp_receipt.Hash), "verify_receipt of a missing receipt")
+}
+
+func TestReceiptsAreReadByTheirParties(t *testing.T) {
+	s := new_receipt_stub(t)
+	expect_ok(t, s.invoke(donor_user, "generate_receipt", "d100", "2026"), "generate_receipt")
+
+	read := func(caller test_identity, args ...string) []string {
+		t.Helper()
+		res := s.invoke(caller, "get_receipts", args...)
+		expect_ok(t, res, "get_receipts")
+		return receipt_ids(t, res.Payload)
+	}
+	expect_ids(t, read(donor_user, "d100"), "n1-2026-000001", "n100-2026-000001")
+	expect_ids(t, read(operator_admin, "d100", "2026"), "n1-2026-000001", "n100-2026-000001")
+	expect_ids(t, read(donor_user, "d100", "2025"))
+	// an NPO reads the receipts it issued
+	expect_ids(t, read(npo_user, "d100"), "n100-2026-000001")
+
+	expect_error(t, s.invoke(other_donor, "get_receipts", "d100"), "get_receipts of another donor")
+	expect_error(t, s.invoke(other_admin, "get_receipts", "d100"), "get_receipts by another org's admin")
+	expect_error(t, s.invoke(donor_user, "get_receipts", "d100", "last year"), "get_receipts with a malformed year")
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// What the caller is shown of recipients. Assets, loans and events name their recipient only to operator admins
+// and to the identities bound to the NPO holding the record, a Recipient record is whole only for its own binding.
+// An npo role alone is not enough, any org can issue npo certificates. Every read path that returns entities or
+// events passes them through a recipient_view, so everyone else sees who got an asset only by type
+type recipient_view struct {
+	stub  shim.ChaincodeStubInterface
+	admin bool
+	npos  map[string]bool // NPO id -> caller is bound to it
+}
+
+func new_recipient_view(stub shim.ChaincodeStubInterface) *recipient_view {
+	return &recipient_view{stub: stub, admin: is_operator_admin(stub), npos: map[string]bool{}}
+}
+
+// Does the caller see the recipients of the NPO's records
+func (view *recipient_view) sees_npo(npoId string) bool {
+	if view.admin {
+		return true
+	}
+	sees, found := view.npos[npoId]
+	if !found {
+		sees = npoId != "" && check_entity_binding(view.stub, "NPO", npoId) == nil
+		view.npos[npoId] = sees
+	}
+	return sees
+}
+
+// Recipient filters on loans and activity are allowed to the recipient's own identity, its delegates and operator admins
+func check_recipient_reader(stub shim.ChaincodeStubInterface, recipientId string) error {
+	return check_private_reader(stub, "Recipient", recipientId)
+}
+
+// Drop the recipient links of an asset. Relations written before recipient details moved to the private
+// collection carry the recipient's name in Username and its type in User_type, both are cleared
+func redact_asset(temp_asset *Asset) {
+	temp_asset.RecipientId = ""
+	for i, v := range temp_asset.Owner_history {
+		v.Username = ""
+		if v.User_type != "Donor" && v.User_type != "NPO" {
+			v.Id = ""
+			v.User_type = "Recipient"
+		}
+		temp_asset.Owner_history[i] = v
+	}
+	// the submitter of a borrow or a receipt confirmation can be the recipient itself
+	for i := range temp_asset.Status_history {
+		temp_asset.Status_history[i].By = ""
+	}
+}
+
+// Redact a decoded entity in place for what the caller may see
+func (view *recipient_view) redact_entity(entity interface{}) {
+	switch v := entity.(type) {
+	case *Asset:
+		if !view.sees_npo(v.NPOId) {
+			redact_asset(v)
+		}
+	case *Loan:
+		if !view.sees_npo(v.NPOId) {
+			v.RecipientId = ""
+		}
+	case *Recipient:
+		if check_binding(view.stub, v.IdentityBinding, v.Id) != nil {
+			v.Asset_array = []string{}
+			v.IdentityBinding = IdentityBinding{}
+		}
+	}
+}
+
+func (view *recipient_view) redact_event(event *ChaincodeEvent) {
+	if event.RecipientId != "" && !view.sees_npo(event.NPOId) {
+		event.RecipientId = ""
+	}
+}
+
+// Redacted form of a stored value. Doctypes without recipient links are returned as they are
+func (view *recipient_view) redact_json(doctype string, valueAsBytes []byte) []byte {
+	if doctype != "Asset" && doctype != "Loan" && doctype != "Recipient" {
+		return valueAsBytes
+	}
+	entity, err := decode_entity(doctype, valueAsBytes)
+	if err != nil {
+		return valueAsBytes
+	}
+	view.redact_entity(entity)
+
+	redactedAsBytes, _ := json.Marshal(entity)
+	return redactedAsBytes
+}
+
+// Redact every record of a list or query page
+func (view *recipient_view) redact_page(doctype string, page *PageResult) {
+	for i, v := range page.Records {
+		page.Records[i] = json.RawMessage(view.redact_json(doctype, v))
+	}
+}
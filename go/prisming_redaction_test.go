@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// a100 given to r100 by n100, waiting for the recipient's receipt
+func new_given_stub(t *testing.T) *mock_stub {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+	expect_ok(t, s.invoke_transient(recipient_user, map[string]interface{}{"recipient": RecipientPrivate{Name: "홍길동", Types: "Temporary", Salt: "s1"}},
+		"enroll_recipient", "r100"), "enroll_recipient")
+	expect_ok(t, s.invoke(donor_user, "propose_asset", "a100", "coat", "d100", "n100", "의류", "hash"), "propose_asset")
+	expect_ok(t, s.invoke(npo_user, "approve_asset", "a100", "n100"), "approve_asset")
+	expect_ok(t, s.invoke(npo_user, "give_asset", "a100", "r100"), "give_asset")
+	return s
+}
+
+func expect_no_recipient(t *testing.T, payload []byte, what string) {
+	t.Helper()
+	if strings.Contains(string(payload), "r100") {
+		t.Fatalf("%s shows the recipient - %s", what, payload)
+	}
+}
+
+func TestSharedReadsHideRecipients(t *testing.T) {
+	s := new_given_stub(t)
+
+	res := s.invoke(donor_user, "query", "Asset", "a100")
+	expect_ok(t, res, "query")
+	expect_no_recipient(t, res.Payload, "query")
+	var temp_asset Asset
+	json.Unmarshal(res.Payload, &temp_asset)
+	if len(temp_asset.Owner_history) == 0 || temp_asset.Owner_history[len(temp_asset.Owner_history)-1].User_type != "Recipient" {
+		t.Fatalf("owner history lost the recipient relation - %+v", temp_asset.Owner_history)
+	}
+
+	res = s.invoke(donor_user, "list_assets", "10")
+	expect_ok(t, res, "list_assets")
+	expect_no_recipient(t, res.Payload, "list_assets")
+
+	res = s.invoke(donor_user, "query_assets", `{"npoid":"n100"}`, "10")
+	expect_ok(t, res, "query_assets")
+	expect_no_recipient(t, res.Payload, "query_assets")
+
+	res = s.invoke(donor_user, "get_history", "a100")
+	expect_ok(t, res, "get_history")
+	expect_no_recipient(t, res.Payload, "get_history")
+
+	res = s.invoke(donor_user, "get_entity_history", "Asset", "a100", "", "", "10")
+	expect_ok(t, res, "get_entity_history")
+	expect_no_recipient(t, res.Payload, "get_entity_history")
+
+	res = s.invoke(donor_user, "read_everything")
+	expect_ok(t, res, "read_everything")
+	var everything struct {
+		Assets     []Asset
+		Recipients []Recipient
+	}
+	json.Unmarshal(res.Payload, &everything)
+	for _, v := range everything.Assets {
+		if v.RecipientId != "" {
+			t.Fatalf("read_everything shows the recipient of %s", v.Id)
+		}
+	}
+	for _, v := range everything.Recipients {
+		if len(v.Asset_array) > 0 || v.Owner != "" {
+			t.Fatalf("read_everything shows the assets or identity of %s", v.Id)
+		}
+	}
+
+	res = s.invoke(donor_user, "get_activity", "2026-03-01T00:00:00Z", "2026-03-03T00:00:00Z", "", "100")
+	expect_ok(t, res, "get_activity")
+	expect_no_recipient(t, res.Payload, "get_activity")
+}
+
+func TestTheAssetsNPOSeesRecipients(t *testing.T) {
+	s := new_given_stub(t)
+
+	res := s.invoke(npo_user, "query", "Asset", "a100")
+	expect_ok(t, res, "query")
+	var temp_asset Asset
+	json.Unmarshal(res.Payload, &temp_asset)
+	if temp_asset.RecipientId != "r100" {
+		t.Fatalf("NPO does not see the recipient - %s", res.Payload)
+	}
+
+	res = s.invoke(npo_user, "get_activity", "2026-03-01T00:00:00Z", "2026-03-03T00:00:00Z", "", "100")
+	expect_ok(t, res, "get_activity")
+	if !strings.Contains(string(res.Payload), "r100") {
+		t.Fatalf("NPO does not see the recipient in its activity - %s", res.Payload)
+	}
+
+	res = s.invoke(operator_admin, "get_history", "a100")
+	expect_ok(t, res, "get_history as the operator's admin")
+	if !strings.Contains(string(res.Payload), "r100") {
+		t.Fatalf("operator's admin does not see the recipient - %s", res.Payload)
+	}
+}
+
+func TestRolesAloneDoNotSeeRecipients(t *testing.T) {
+	s := new_given_stub(t)
+
+	// an npo certificate not bound to n100, and an admin of another org
+	other_npo := new_test_identity("OtherOrgMSP", RoleNPO, "npo2")
+	for _, caller := range []test_identity{other_npo, other_admin} {
+		res := s.invoke(caller, "query", "Asset", "a100")
+		expect_ok(t, res, "query")
+		expect_no_recipient(t, res.Payload, "query by "+caller.Cert.Subject.CommonName)
+
+		res = s.invoke(caller, "list_assets", "10")
+		expect_ok(t, res, "list_assets")
+		expect_no_recipient(t, res.Payload, "list_assets by "+caller.Cert.Subject.CommonName)
+
+		res = s.invoke(caller, "get_history", "a100")
+		expect_ok(t, res, "get_history")
+		expect_no_recipient(t, res.Payload, "get_history by "+caller.Cert.Subject.CommonName)
+
+		res = s.invoke(caller, "get_activity", "2026-03-01T00:00:00Z", "2026-03-03T00:00:00Z", "", "100")
+		expect_ok(t, res, "get_activity")
+		expect_no_recipient(t, res.Payload, "get_activity by "+caller.Cert.Subject.CommonName)
+
+		expect_error(t, s.invoke(caller, "get_activity", "2026-03-01T00:00:00Z", "2026-03-03T00:00:00Z", `{"recipientId":"r100"}`, "100"),
+			"get_activity by recipient as "+caller.Cert.Subject.CommonName)
+	}
+}
+
+func TestBlockEventsLeaveRecipientsOut(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+
+	expect_ok(t, s.invoke_transient(recipient_user, map[string]interface{}{"recipient": RecipientPrivate{Name: "홍길동", Types: "Temporary", Salt: "s1"}},
+		"enroll_recipient", "r100"), "enroll_recipient")
+	expect_no_recipient(t, s.event, "recipient_enrolled event")
+
+	expect_ok(t, s.invoke(donor_user, "propose_asset", "a100", "coat", "d100", "n100", "의류", "hash"), "propose_asset")
+	expect_ok(t, s.invoke(npo_user, "approve_asset", "a100", "n100"), "approve_asset")
+	expect_ok(t, s.invoke(npo_user, "give_asset", "a100", "r100"), "give_asset")
+	var envelope EventEnvelope
+	json.Unmarshal(s.event, &envelope)
+	if len(envelope.Events) != 1 || envelope.Events[0].Name != "asset_given" {
+		t.Fatalf("unexpected events %s", s.event)
+	}
+	expect_no_recipient(t, s.event, "asset_given event")
+}
+
+func TestRecipientFiltersNeedTheRecipient(t *testing.T) {
+	s := new_given_stub(t)
+
+	expect_error(t, s.invoke(donor_user, "get_activity", "2026-03-01T00:00:00Z", "2026-03-03T00:00:00Z", `{"recipientId":"r100"}`, "100"),
+		"get_activity by recipient as a donor")
+	expect_error(t, s.invoke(other_recip, "get_overdue_loans", "recipient", "r100"), "get_overdue_loans as another recipient")
+
+	res := s.invoke(recipient_user, "get_activity", "2026-03-01T00:00:00Z", "2026-03-03T00:00:00Z", `{"recipientId":"r100"}`, "100")
+	expect_ok(t, res, "get_activity by the recipient")
+	if !strings.Contains(string(res.Payload), "r100") {
+		t.Fatalf("recipient does not see its own activity - %s", res.Payload)
+	}
+	expect_ok(t, s.invoke(recipient_user, "get_overdue_loans", "recipient", "r100"), "get_overdue_loans by the recipient")
+
+	// a recipient reads its own record whole
+	res = s.invoke(recipient_user, "query", "Recipient", "r100")
+	expect_ok(t, res, "query own recipient")
+	if !strings.Contains(string(res.Payload), "a100") {
+		t.Fatalf("recipient does not see its own assets - %s", res.Payload)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"time"
+)
+
+// Report window from RFC3339 args, "" for open ended. Bounds are returned in UTC RFC3339 so they compare
+// as strings with stored timestamps
+func parse_report_window(fromArg string, toArg string) (string, string, error) {
+	bounds := []string{"", ""}
+	for i, v := range []string{fromArg, toArg} {
+		if v == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return "", "", fmt.Errorf("{\"Error\":\"Dates must be RFC3339 - %s\"}", v)
+		}
+		bounds[i] = parsed.UTC().Format(time.RFC3339)
+	}
+	return bounds[0], bounds[1], nil
+}
+
+func in_window(timestamp string, from string, to string) bool {
+	if timestamp == "" {
+		return false
+	}
+	return (from == "" || timestamp >= from) && (to == "" || timestamp <= to)
+}
+
+// Timestamp of the first status change with action, "" when there is none
+func action_time(history []StatusChange, action string) string {
+	for _, v := range history {
+		if v.Action == action {
+			return v.Timestamp
+		}
+	}
+	return ""
+}
+
+// Tx time the asset was given, "" when it is not Given. A give counts once the recipient confirmed it,
+// assets given before confirmations went straight to Given
+func given_time(temp_asset Asset) string {
+	if temp_asset.Status != StatusGiven {
+		return ""
+	}
+	given := action_time(temp_asset.Status_history, "confirm_receipt")
+	if given == "" {
+		given = action_time(temp_asset.Status_history, "give")
+	}
+	return given
+}
+
+// ============================================================================================================================
+// npo_impact_report - args: npo id, from, to (RFC3339, "" for open ended). Activity of the NPO in the window,
+// counted from the status changes of its assets and needs and from its loans. For the NPO and operator admins
+// ============================================================================================================================
+func (t *SimpleChaincode) npo_impact_report(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	type ImpactReport struct {
+		NPOId                 string  `json:"npoid"`
+		From                  string  `json:"from"`
+		To                    string  `json:"to"`
+		AssetsProposed        int     `json:"assetsProposed"`
+		AssetsApproved        int     `json:"assetsApproved"`
+		AssetsRejected        int     `json:"assetsRejected"`
+		ItemsApproved         int     `json:"itemsApproved"`
+		NeedsOpened           int     `json:"needsOpened"`
+		NeedsCompleted        int     `json:"needsCompleted"`
+		NeedsCompletedPercent float64 `json:"needsCompletedPercent"` // of the needs opened in the window
+		ItemsLent             int     `json:"itemsLent"`
+		ItemsGiven            int     `json:"itemsGiven"`
+		AvgHoursToApproval    float64 `json:"avgHoursToApproval"`
+		UniqueDonors          int     `json:"uniqueDonors"`
+		UniqueRecipients      int     `json:"uniqueRecipients"`
+	}
+
+	if len(args) != 3 {
+		return shim.Error("Incorrect number of arguments. Expecting 3")
+	}
+	from, to, err := parse_report_window(args[1], args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	temp_npo_by_byte, err := get_state(stub, "NPO", args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if temp_npo_by_byte == nil {
+		jsonResp := "{\"Error\":\"NPO " + args[0] + " does not exist\"}"
+		return shim.Error(jsonResp)
+	}
+	var temp_npo NPO
+	json.Unmarshal(temp_npo_by_byte, &temp_npo)
+
+	err = check_binding(stub, temp_npo.IdentityBinding, temp_npo.Id)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var report ImpactReport
+	report.NPOId = args[0]
+	report.From = from
+	report.To = to
+	donors := map[string]bool{}
+	recipients := map[string]bool{}
+
+	// ---- Assets ---- //
+	assetIds, err := index_ids(stub, "Asset", "npoid", args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	approvalHours := 0.0
+	for _, id := range assetIds {
+		temp_asset_by_byte, err := get_state(stub, "Asset", id)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if temp_asset_by_byte == nil {
+			continue
+		}
+		var temp_asset Asset
+		json.Unmarshal(temp_asset_by_byte, &temp_asset)
+
+		proposed := action_time(temp_asset.Status_history, "propose")
+		approved := action_time(temp_asset.Status_history, "approve")
+		if in_window(approved, from, to) {
+			report.ItemsApproved = report.ItemsApproved + asset_quantity(temp_asset)
+		}
+		given := given_time(temp_asset)
+		if in_window(given, from, to) {
+			report.ItemsGiven = report.ItemsGiven + asset_quantity(temp_asset)
+			recipientId := temp_asset.RecipientId
+			if recipientId == "" && len(temp_asset.Owner_history) > 0 {
+				recipientId = temp_asset.Owner_history[len(temp_asset.Owner_history)-1].Id
+			}
+			recipients[recipientId] = true
+		}
+
+		// parts split off on approval share the proposal, count it once
+		if temp_asset.Parent_id != "" {
+			continue
+		}
+		if in_window(proposed, from, to) {
+			report.AssetsProposed++
+			donors[temp_asset.DonorId] = true
+		}
+		if in_window(action_time(temp_asset.Status_history, "reject"), from, to) {
+			report.AssetsRejected++
+		}
+		if in_window(approved, from, to) {
+			report.AssetsApproved++
+			proposedTime, err1 := time.Parse(time.RFC3339, proposed)
+			approvedTime, err2 := time.Parse(time.RFC3339, approved)
+			if err1 == nil && err2 == nil {
+				approvalHours = approvalHours + approvedTime.Sub(proposedTime).Hours()
+			}
+		}
+	}
+	if report.AssetsApproved > 0 {
+		report.AvgHoursToApproval = approvalHours / float64(report.AssetsApproved)
+	}
+
+	// ---- Needs ---- //
+	needIds, err := index_ids(stub, "Need", "npoid", args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	openedCompleted := 0
+	for _, id := range needIds {
+		temp_need_by_byte, err := get_state(stub, "Need", id)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if temp_need_by_byte == nil {
+			continue
+		}
+		var temp_need Need
+		json.Unmarshal(temp_need_by_byte, &temp_need)
+
+		completed := action_time(temp_need.Status_history, "complete")
+		if in_window(completed, from, to) {
+			report.NeedsCompleted++
+		}
+		if in_window(temp_need.Created, from, to) {
+			report.NeedsOpened++
+			if temp_need.Status == NeedComplete {
+				openedCompleted++
+			}
+		}
+	}
+	if report.NeedsOpened > 0 {
+		report.NeedsCompletedPercent = float64(openedCompleted) * 100 / float64(report.NeedsOpened)
+	}
+
+	// ---- Loans ---- //
+	loanIds, err := index_ids(stub, "Loan", "npoid", args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, id := range loanIds {
+		temp_loan, err := get_loan(stub, id)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !in_window(temp_loan.Borrowed, from, to) {
+			continue
+		}
+		temp_asset_by_byte, err := get_state(stub, "Asset", temp_loan.AssetId)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		var temp_asset Asset
+		json.Unmarshal(temp_asset_by_byte, &temp_asset)
+		report.ItemsLent = report.ItemsLent + asset_quantity(temp_asset)
+		recipients[temp_loan.RecipientId] = true
+	}
+
+	delete(recipients, "")
+	report.UniqueDonors = len(donors)
+	report.UniqueRecipients = len(recipients)
+
+	reportAsBytes, _ := json.Marshal(report)
+	return shim.Success(reportAsBytes)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+type impact_report struct {
+	AssetsProposed        int     `json:"assetsProposed"`
+	AssetsApproved        int     `json:"assetsApproved"`
+	AssetsRejected        int     `json:"assetsRejected"`
+	ItemsApproved         int     `json:"itemsApproved"`
+	NeedsOpened           int     `json:"needsOpened"`
+	NeedsCompleted        int     `json:"needsCompleted"`
+	NeedsCompletedPercent float64 `json:"needsCompletedPercent"`
+	ItemsLent             int     `json:"itemsLent"`
+	ItemsGiven            int     `json:"itemsGiven"`
+	AvgHoursToApproval    float64 `json:"avgHoursToApproval"`
+	UniqueDonors          int     `json:"uniqueDonors"`
+	UniqueRecipients      int     `json:"uniqueRecipients"`
+}
+
+func (s *mock_stub) impact_report(t *testing.T, caller test_identity, args ...string) impact_report {
+	t.Helper()
+	res := s.invoke(caller, "npo_impact_report", args...)
+	expect_ok(t, res, "npo_impact_report")
+	var report impact_report
+	json.Unmarshal(res.Payload, &report)
+	return report
+}
+
+func TestImpactReportCountsTheNPOsActivity(t *testing.T) {
+	s := new_mock_stub(t)
+	s.enroll_test_parties(t)
+	expect_ok(t, s.invoke_transient(other_donor, map[string]interface{}{"donor": DonorPrivate{Phone: "010-0000-0002", Salt: "s2"}},
+		"enroll_donor", "d101", "donor two"), "enroll_donor d101")
+	expect_ok(t, s.invoke_transient(recipient_user, map[string]interface{}{"recipient": RecipientPrivate{Name: "홍길동", Types: "Temporary", Salt: "s1"}},
+		"enroll_recipient", "r100"), "enroll_recipient r100")
+	expect_ok(t, s.invoke_transient(other_recip, map[string]interface{}{"recipient": RecipientPrivate{Name: "김철수", Types: "Permanent", Salt: "s2"}},
+		"enroll_recipient", "r101"), "enroll_recipient r101")
+	expect_ok(t, s.invoke(npo_user, "enroll_needs", "e100", "n100", "셔츠", "의류", "2"), "enroll_needs e100")
+	expect_ok(t, s.invoke(npo_user, "enroll_needs", "e101", "n100", "라면", "식품", "10"), "enroll_needs e101")
+
+	// a100 approved two hours after its proposal, a101 a minute after, a102 rejected
+	expect_ok(t, s.invoke(donor_user, "propose_asset", "a100", "셔츠", "d100", "n100", "의류", "hash", "", "2"), "propose_asset a100")
+	s.txCount += 119
+	expect_ok(t, s.invoke(npo_user, "approve_asset", "a100", "n100"), "approve_asset a100")
+	expect_ok(t, s.invoke(other_donor, "propose_asset", "a101", "라면", "d101", "n100", "식품", "hash", "", "3"), "propose_asset a101")
+	expect_ok(t, s.invoke(npo_user, "approve_asset", "a101", "n100"), "approve_asset a101")
+	expect_ok(t, s.invoke(donor_user, "propose_asset", "a102", "모자", "d100", "n100", "의류", "hash"), "propose_asset a102")
+	expect_ok(t, s.invoke(npo_user, "reject_asset", "a102", "n100", "no hats"), "reject_asset")
+
+	// a100 lent to r100, then given to r101
+	expect_ok(t, s.invoke(recipient_user, "borrow_asset", "a100", "r100"), "borrow_asset")
+	expect_ok(t, s.invoke(npo_user, "get_back_asset", "a100", "r100"), "get_back_asset")
+	expect_ok(t, s.invoke(npo_user, "give_asset", "a100", "r101"), "give_asset")
+	expect_ok(t, s.invoke(other_recip, "confirm_receipt", "a100"), "confirm_receipt")
+
+	report := s.impact_report(t, npo_user, "n100", "", "")
+	want := impact_report{
+		AssetsProposed: 3, AssetsApproved: 2, AssetsRejected: 1, ItemsApproved: 5,
+		NeedsOpened: 2, NeedsCompleted: 1, NeedsCompletedPercent: 50,
+		ItemsLent: 2, ItemsGiven: 2, AvgHoursToApproval: (2 + 1.0/60) / 2,
+		UniqueDonors: 2, UniqueRecipients: 2,
+	}
+	if report != want {
+		t.Fatalf("unexpected report\n%+v, expected\n%+v", report, want)
+	}
+
+	// nothing happened after the last transaction
+	later := s.next_time().Format(time.RFC3339)
+	if report = s.impact_report(t, operator_admin, "n100", later, ""); report != (impact_report{}) {
+		t.Fatalf("unexpected report of an empty window %+v", report)
+	}
+
+	expect_error(t, s.invoke(npo_user, "npo_impact_report", "n1", "", ""), "npo_impact_report of another NPO")
+	expect_error(t, s.invoke(donor_user, "npo_impact_report", "n100", "", ""), "npo_impact_report by a donor")
+	expect_error(t, s.invoke(npo_user, "npo_impact_report", "n999", "", ""), "npo_impact_report of a missing NPO")
+	expect_error(t, s.invoke(npo_user, "npo_impact_report", "n100", "last quarter", ""), "npo_impact_report with a malformed window")
+}